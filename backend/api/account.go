@@ -0,0 +1,464 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+
+	authservice "encore.app/backend/auth"
+)
+
+type DeleteAccountParams struct {
+	// TransferTo is the username of the profile that receives the recipes
+	// listed in TransferRecipeIds before the account is deleted. It must be
+	// a member of one of the caller's households.
+	TransferTo        string   `query:"transfer_to"`
+	TransferRecipeIds []string `query:"transfer_recipe_ids"`
+}
+
+// dataExportSections lists the files in the data export archive and the
+// queries producing them. Each query selects a single JSON array and takes
+// the caller's profile ID as $1. API tokens are stored by the auth service
+// and added as apiTokensExportFile.
+var dataExportSections = []struct {
+	filename string
+	query    string
+}{
+	{"profile.json", `
+		SELECT COALESCE(json_agg(t), '[]')
+		FROM (SELECT id, username, display_name, bio, avatar_url, links FROM profile WHERE id = $1) t
+	`},
+	{"recipes.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (SELECT * FROM recipe WHERE profile_id = $1) t
+	`},
+	{"images.json", `
+		SELECT COALESCE(json_agg(t), '[]')
+		FROM (SELECT id AS recipe_id, slug, image_url FROM recipe WHERE profile_id = $1 AND image_url <> '') t
+	`},
+	{"cook_log.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.cooked_on), '[]')
+		FROM (SELECT * FROM cook_log WHERE profile_id = $1) t
+	`},
+	{"favorites.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (SELECT * FROM recipe_favorite WHERE profile_id = $1) t
+	`},
+	{"ratings.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (SELECT * FROM recipe_rating WHERE profile_id = $1) t
+	`},
+	{"comments.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (SELECT * FROM recipe_comment WHERE profile_id = $1) t
+	`},
+	{"following.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (
+			SELECT p.username, f.created_at
+			FROM profile_follow f
+			INNER JOIN profile p ON f.followee_id = p.id
+			WHERE f.follower_id = $1
+		) t
+	`},
+	{"households.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.joined_at), '[]')
+		FROM (
+			SELECT h.id, h.name, m.role, m.joined_at
+			FROM household_member m
+			INNER JOIN household h ON m.household_id = h.id
+			WHERE m.profile_id = $1
+		) t
+	`},
+	{"meal_plan.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.planned_on), '[]')
+		FROM (SELECT planned_on, meal, recipe_id, note, created_at FROM meal_plan_entry WHERE profile_id = $1) t
+	`},
+	{"shopping_lists.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (
+			SELECT l.id, l.name, l.created_at,
+			       COALESCE((
+					SELECT json_agg(json_build_object('text', i.text, 'checked', i.checked, 'recipe_id', i.recipe_id) ORDER BY i.created_at)
+					FROM shopping_list_item i
+					WHERE i.shopping_list_id = l.id
+			       ), '[]') AS items
+			FROM shopping_list l
+			WHERE l.profile_id = $1
+		) t
+	`},
+	{"reports.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (
+			SELECT target_type, target_id, reason, details, status, resolution_note, resolved_at, created_at
+			FROM content_report
+			WHERE reporter_id = $1
+		) t
+	`},
+	{"ai_usage.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (
+			SELECT kind, model, prompt_tokens, completion_tokens, total_tokens, status, created_at
+			FROM ai_usage
+			WHERE profile_id = $1
+		) t
+	`},
+	{"recipe_drafts.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]')
+		FROM (SELECT source, recipe, created_at, expires_at FROM recipe_draft WHERE profile_id = $1) t
+	`},
+	{"cook_stages.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.recipe_id, t.position), '[]')
+		FROM (
+			SELECT s.*
+			FROM recipe_cook_stage s
+			INNER JOIN recipe r ON s.recipe_id = r.id
+			WHERE r.profile_id = $1
+		) t
+	`},
+	{"recipe_steps.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.recipe_id, t.position), '[]')
+		FROM (
+			SELECT s.*
+			FROM recipe_step s
+			INNER JOIN recipe r ON s.recipe_id = r.id
+			WHERE r.profile_id = $1
+		) t
+	`},
+	{"recipe_slug_history.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.changed_at), '[]')
+		FROM (SELECT recipe_id, slug, changed_at FROM recipe_slug_history WHERE profile_id = $1) t
+	`},
+	{"tags.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.name), '[]')
+		FROM (SELECT name, created_at FROM tag WHERE profile_id = $1) t
+	`},
+	{"username_history.json", `
+		SELECT COALESCE(json_agg(t ORDER BY t.changed_at), '[]')
+		FROM (SELECT username, changed_at FROM username_history WHERE profile_id = $1) t
+	`},
+}
+
+// apiTokensExportFile lists the caller's API tokens in the data export. The
+// auth service never returns token hashes, only their metadata.
+const apiTokensExportFile = "api_tokens.json"
+
+// ExportMyData returns everything stored about the caller as a zip archive
+// of JSON files.
+//
+//encore:api auth raw method=GET path=/api/me/data-export tag:read
+func ExportMyData(w http.ResponseWriter, req *http.Request) {
+	profileId, err := authservice.RequireInteractiveUser()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ctx := req.Context()
+
+	// Run all queries before writing anything, so a failure can still be
+	// reported with a proper status code.
+	filenames := make([]string, 0, len(dataExportSections)+1)
+	files := make(map[string][]byte, len(dataExportSections)+1)
+	for _, section := range dataExportSections {
+		var data []byte
+		if err := db.QueryRow(ctx, section.query, profileId).Scan(&data); err != nil {
+			rlog.Error("error exporting data", "file", section.filename, "err", err)
+			http.Error(w, "error exporting data", http.StatusInternalServerError)
+			return
+		}
+		filenames = append(filenames, section.filename)
+		files[section.filename] = data
+	}
+
+	apiTokens, err := authservice.ListApiTokens(ctx)
+	if err == nil {
+		files[apiTokensExportFile], err = json.Marshal(apiTokens.ApiTokens)
+	}
+	if err != nil {
+		rlog.Error("error exporting data", "file", apiTokensExportFile, "err", err)
+		http.Error(w, "error exporting data", http.StatusInternalServerError)
+		return
+	}
+	filenames = append(filenames, apiTokensExportFile)
+
+	filename := fmt.Sprintf("recipes-data-export-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	archive := zip.NewWriter(w)
+	for _, filename := range filenames {
+		f, err := archive.Create(filename)
+		if err != nil {
+			rlog.Error("error writing data export", "file", filename, "err", err)
+			return
+		}
+		if err := writeIndentedJSON(f, files[filename]); err != nil {
+			rlog.Error("error writing data export", "file", filename, "err", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		rlog.Error("error writing data export", "err", err)
+	}
+}
+
+// DeleteMyAccount deletes the caller's profile and everything that belongs to
+// it, optionally handing some recipes over to another user first, and then
+// removes the caller's sign-in account.
+//
+//encore:api auth method=DELETE path=/api/me
+func DeleteMyAccount(ctx context.Context, params *DeleteAccountParams) error {
+	profileId, err := authservice.RequireInteractiveUser()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(params.TransferRecipeIds) > 0 {
+		if err := transferRecipes(ctx, tx, profileId, params.TransferTo, params.TransferRecipeIds); err != nil {
+			return err
+		}
+	}
+
+	if err := handOverHouseholds(ctx, tx, profileId); err != nil {
+		return err
+	}
+
+	if err := transferHouseholdRecipes(ctx, tx, profileId); err != nil {
+		return err
+	}
+
+	// Recipes, cook logs, favorites, ratings, comments, follows, household
+	// memberships and username history are removed by ON DELETE CASCADE.
+	_, err = tx.Exec(ctx, `DELETE FROM profile WHERE id = $1`, profileId)
+	if err != nil {
+		return fmt.Errorf("error deleting profile: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := authservice.DeleteUser(ctx, profileId); err != nil {
+		rlog.Error("error deleting sign-in account", "uid", profileId, "err", err)
+		return fmt.Errorf("your data was deleted but your sign-in account could not be removed, please try again")
+	}
+
+	return nil
+}
+
+// transferRecipes moves the given recipes from one profile to another. Slugs
+// that clash with the new owner's recipes get a numeric suffix. Recipes can
+// only be given to someone the profile shares a household with, so nobody
+// is handed recipes by a stranger.
+func transferRecipes(ctx context.Context, tx *sqldb.Tx, fromProfileId string, toUsername string, recipeIds []string) error {
+	if toUsername == "" {
+		return fmt.Errorf("a username to transfer recipes to is required")
+	}
+
+	var toProfileId string
+	err := tx.QueryRow(ctx, `
+		SELECT id
+		FROM profile
+		WHERE LOWER(username) = LOWER($1)
+	`, toUsername).Scan(&toProfileId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("profile not found")
+		}
+		return err
+	}
+	if toProfileId == fromProfileId {
+		return fmt.Errorf("cannot transfer recipes to yourself")
+	}
+
+	var sharesHousehold bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM household_member mine
+			INNER JOIN household_member theirs ON theirs.household_id = mine.household_id
+			WHERE mine.profile_id = $1 AND theirs.profile_id = $2
+		)
+	`, fromProfileId, toProfileId).Scan(&sharesHousehold)
+	if err != nil {
+		return err
+	}
+	if !sharesHousehold {
+		return fmt.Errorf("recipes can only be transferred to a member of one of your households")
+	}
+
+	return moveRecipes(ctx, tx, fromProfileId, toProfileId, recipeIds, false)
+}
+
+// transferHouseholdRecipes hands the profile's household-shared recipes to
+// the owner of each household, so the household keeps them when the profile
+// is deleted. Recipes of households without another owner are left alone;
+// handOverHouseholds has already made sure every remaining household has one.
+func transferHouseholdRecipes(ctx context.Context, tx *sqldb.Tx, profileId string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT r.id, o.profile_id
+		FROM recipe r
+		INNER JOIN LATERAL (
+			SELECT m.profile_id
+			FROM household_member m
+			WHERE m.household_id = r.household_id AND m.profile_id <> r.profile_id AND m.role = $2
+			ORDER BY m.joined_at
+			LIMIT 1
+		) o ON TRUE
+		WHERE r.profile_id = $1 AND r.household_id IS NOT NULL
+	`, profileId, HouseholdRoleOwner)
+	if err != nil {
+		return fmt.Errorf("error transferring household recipes: %w", err)
+	}
+	defer rows.Close()
+
+	recipeIdsByOwner := make(map[string][]string)
+	for rows.Next() {
+		var recipeId, ownerId string
+		if err := rows.Scan(&recipeId, &ownerId); err != nil {
+			return err
+		}
+		recipeIdsByOwner[ownerId] = append(recipeIdsByOwner[ownerId], recipeId)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over rows: %v", err)
+	}
+	rows.Close()
+
+	for ownerId, recipeIds := range recipeIdsByOwner {
+		if err := moveRecipes(ctx, tx, profileId, ownerId, recipeIds, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveRecipes changes the owner of the recipes. Recipes stay shared with
+// their household only if keepHousehold is set, i.e. the new owner is a
+// member of it.
+func moveRecipes(ctx context.Context, tx *sqldb.Tx, fromProfileId string, toProfileId string, recipeIds []string, keepHousehold bool) error {
+	for _, recipeId := range recipeIds {
+		var slug, title string
+		err := tx.QueryRow(ctx, `
+			SELECT slug, title
+			FROM recipe
+			WHERE id = $1 AND profile_id = $2
+			FOR UPDATE
+		`, recipeId, fromProfileId).Scan(&slug, &title)
+		if err != nil {
+			return fmt.Errorf("recipe %s not found", recipeId)
+		}
+
+		exists, err := checkSlugExists(ctx, tx, slug, toProfileId, "")
+		if err != nil {
+			return err
+		}
+		if exists {
+			slug, err = createUniqueSlug(ctx, tx, title, toProfileId)
+			if err != nil {
+				return fmt.Errorf("error generating slug: %w", err)
+			}
+		}
+
+		// Old links belonged to the previous owner's username, so the slug history goes too.
+		_, err = tx.Exec(ctx, `DELETE FROM recipe_slug_history WHERE recipe_id = $1`, recipeId)
+		if err != nil {
+			return fmt.Errorf("error transferring recipe: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE recipe
+			SET profile_id = $2, slug = $3, household_id = CASE WHEN $4 THEN household_id END, updated_at = NOW()
+			WHERE id = $1
+		`, recipeId, toProfileId, slug, keepHousehold)
+		if err != nil {
+			return fmt.Errorf("error transferring recipe: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// handOverHouseholds makes sure households the profile owns alone keep an
+// owner: the longest-standing remaining member is promoted, and households
+// with no other members are deleted.
+func handOverHouseholds(ctx context.Context, tx *sqldb.Tx, profileId string) error {
+	// Lock the member rows of the profile's households, as
+	// lockHouseholdMembers does, so a role change made at the same time
+	// cannot leave one of them without an owner.
+	_, err := tx.Exec(ctx, `
+		SELECT 1
+		FROM household_member
+		WHERE household_id IN (SELECT household_id FROM household_member WHERE profile_id = $1)
+		FOR UPDATE
+	`, profileId)
+	if err != nil {
+		return fmt.Errorf("error locking households: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE household_member m
+		SET role = $2
+		FROM (
+			SELECT DISTINCT ON (o.household_id) o.household_id, o.profile_id
+			FROM household_member me
+			INNER JOIN household_member o ON o.household_id = me.household_id AND o.profile_id <> me.profile_id
+			WHERE me.profile_id = $1 AND me.role = $2
+			  AND NOT EXISTS (
+				SELECT 1
+				FROM household_member other_owner
+				WHERE other_owner.household_id = me.household_id
+				  AND other_owner.profile_id <> me.profile_id
+				  AND other_owner.role = $2
+			  )
+			ORDER BY o.household_id, o.joined_at
+		) successor
+		WHERE m.household_id = successor.household_id AND m.profile_id = successor.profile_id
+	`, profileId, HouseholdRoleOwner)
+	if err != nil {
+		return fmt.Errorf("error handing over households: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM household h
+		WHERE EXISTS (
+			SELECT 1 FROM household_member WHERE household_id = h.id AND profile_id = $1
+		) AND NOT EXISTS (
+			SELECT 1 FROM household_member WHERE household_id = h.id AND profile_id <> $1
+		)
+	`, profileId)
+	if err != nil {
+		return fmt.Errorf("error deleting households: %w", err)
+	}
+
+	return nil
+}
+
+func writeIndentedJSON(w io.Writer, data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
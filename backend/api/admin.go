@@ -0,0 +1,342 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/types/uuid"
+
+	authservice "encore.app/backend/auth"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+type AdminUserListParams struct {
+	// Query filters users by a case-insensitive username substring.
+	Query  string `query:"query"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+type AdminPageParams struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+type AdminUser struct {
+	Id          string     `json:"id"`
+	Username    string     `json:"username"`
+	RecipeCount int        `json:"recipe_count"`
+	BannedAt    *time.Time `json:"banned_at"`
+	BanReason   string     `json:"ban_reason"`
+}
+
+type AdminUserListResponse struct {
+	Users []*AdminUser `json:"users"`
+}
+
+type ReassignRecipeRequest struct {
+	Username string `json:"username"`
+}
+
+type SetRecipeHiddenRequest struct {
+	Hidden bool   `json:"hidden"`
+	Reason string `json:"reason"`
+}
+
+type SetProfileBannedRequest struct {
+	Banned bool   `json:"banned"`
+	Reason string `json:"reason"`
+}
+
+type ModerationAction struct {
+	Id         string    `json:"id"`
+	AdminId    string    `json:"admin_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetId   string    `json:"target_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ModerationLogResponse struct {
+	Actions []*ModerationAction `json:"actions"`
+}
+
+//encore:api auth method=GET path=/api/admin/users tag:read
+func AdminListUsers(ctx context.Context, params *AdminUserListParams) (*AdminUserListResponse, error) {
+	if _, err := requireAdmin(); err != nil {
+		return nil, err
+	}
+
+	limit, offset := adminPage(params.Limit, params.Offset)
+	rows, err := db.Query(ctx, `
+		SELECT p.id, p.username, (SELECT COUNT(*) FROM recipe WHERE profile_id = p.id), p.banned_at, p.ban_reason
+		FROM profile p
+		WHERE $1 = '' OR p.username ILIKE '%' || $1 || '%'
+		ORDER BY p.username
+		LIMIT $2 OFFSET $3
+	`, params.Query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*AdminUser{}
+	for rows.Next() {
+		u := &AdminUser{}
+		if err := rows.Scan(&u.Id, &u.Username, &u.RecipeCount, &u.BannedAt, &u.BanReason); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &AdminUserListResponse{Users: users}, nil
+}
+
+//encore:api auth method=POST path=/api/admin/recipes/:id/reassign
+func AdminReassignRecipe(ctx context.Context, id string, req *ReassignRecipeRequest) (*GenerateRecipeResponse, error) {
+	adminId, err := requireAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	fromProfileId, err := getRecipeProfileId(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := transferRecipes(ctx, tx, fromProfileId, req.Username, []string{id}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("reassigned from %s to %s", fromProfileId, req.Username)
+	if err := logModerationAction(ctx, adminId, "reassign_recipe", "recipe", id, reason); err != nil {
+		return nil, err
+	}
+
+	return getAddRecipeResponse(ctx, id)
+}
+
+//encore:api auth method=POST path=/api/admin/recipes/:id/hidden
+func AdminSetRecipeHidden(ctx context.Context, id string, req *SetRecipeHiddenRequest) error {
+	adminId, err := requireAdmin()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(ctx, `UPDATE recipe SET hidden = $2, hidden_by_reports = FALSE WHERE id = $1`, id, req.Hidden)
+	if err != nil {
+		return fmt.Errorf("error updating recipe: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("recipe not found")
+	}
+
+	action := "unhide_recipe"
+	if req.Hidden {
+		action = "hide_recipe"
+	}
+	return logModerationAction(ctx, adminId, action, "recipe", id, req.Reason)
+}
+
+//encore:api auth method=POST path=/api/admin/profiles/:id/banned
+func AdminSetProfileBanned(ctx context.Context, id string, req *SetProfileBannedRequest) error {
+	adminId, err := requireAdmin()
+	if err != nil {
+		return err
+	}
+	if id == adminId {
+		return fmt.Errorf("cannot ban yourself")
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE profile
+		SET banned_at = CASE WHEN $2 THEN COALESCE(banned_at, NOW()) END,
+		    ban_reason = CASE WHEN $2 THEN $3 ELSE '' END
+		WHERE id = $1
+	`, id, req.Banned, req.Reason)
+	if err != nil {
+		return fmt.Errorf("error updating profile: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("profile not found")
+	}
+
+	action := "unban_profile"
+	if req.Banned {
+		action = "ban_profile"
+	}
+	return logModerationAction(ctx, adminId, action, "profile", id, req.Reason)
+}
+
+//encore:api auth method=GET path=/api/admin/moderation-log tag:read
+func AdminGetModerationLog(ctx context.Context, params *AdminPageParams) (*ModerationLogResponse, error) {
+	if _, err := requireAdmin(); err != nil {
+		return nil, err
+	}
+
+	limit, offset := adminPage(params.Limit, params.Offset)
+	rows, err := db.Query(ctx, `
+		SELECT id, admin_id, action, target_type, target_id, reason, created_at
+		FROM moderation_action
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*ModerationAction{}
+	for rows.Next() {
+		a := &ModerationAction{}
+		if err := rows.Scan(&a.Id, &a.AdminId, &a.Action, &a.TargetType, &a.TargetId, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &ModerationLogResponse{Actions: actions}, nil
+}
+
+// isRecipeListable reports whether the recipe may appear publicly, i.e.
+// neither it nor its owner is hidden and its owner is not banned.
+func isRecipeListable(ctx context.Context, recipeId string) (bool, error) {
+	var listable bool
+	err := db.QueryRow(ctx, `
+		SELECT `+recipeListableCondition+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE r.id = $1
+	`, recipeId).Scan(&listable)
+
+	if err != nil {
+		return false, err
+	}
+
+	return listable, nil
+}
+
+// checkRecipeVisible returns errRecipeNotFound unless the recipe exists and
+// is listable or belongs to profileId.
+func checkRecipeVisible(ctx context.Context, recipeId string, profileId string) error {
+	var listable bool
+	var ownerId string
+	err := db.QueryRow(ctx, `
+		SELECT `+recipeListableCondition+`, r.profile_id
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE r.id = $1
+	`, recipeId).Scan(&listable, &ownerId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRecipeNotFound
+		}
+		return err
+	}
+	if !listable && ownerId != profileId {
+		return errRecipeNotFound
+	}
+
+	return nil
+}
+
+// isAdmin reports whether the caller has the admin custom claim.
+func isAdmin() bool {
+	userData, _ := auth.Data().(*authservice.UserData)
+	return userData != nil && userData.IsAdmin
+}
+
+// requireAdmin returns the caller's profile ID, or an error if the caller is
+// not an admin. Admin actions cannot be taken with an API token.
+func requireAdmin() (string, error) {
+	profileId, err := authservice.RequireInteractiveUser()
+	if err != nil {
+		return "", err
+	}
+	if !isAdmin() {
+		return "", fmt.Errorf("not authorized")
+	}
+
+	return profileId, nil
+}
+
+// checkNotBanned returns an error if the profile has been banned. Banned
+// profiles can still read, but cannot publish anything.
+func checkNotBanned(ctx context.Context, profileId string) error {
+	var banned bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM profile
+			WHERE id = $1 AND banned_at IS NOT NULL
+		)
+	`, profileId).Scan(&banned)
+
+	if err != nil {
+		return err
+	}
+	if banned {
+		return fmt.Errorf("your account has been suspended")
+	}
+
+	return nil
+}
+
+func logModerationAction(ctx context.Context, adminId string, action string, targetType string, targetId string, reason string) error {
+	actionId, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating moderation action ID: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO moderation_action (id, admin_id, action, target_type, target_id, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, actionId.String(), adminId, action, targetType, targetId, reason)
+	if err != nil {
+		return fmt.Errorf("error logging moderation action: %w", err)
+	}
+
+	return nil
+}
+
+func adminPage(limit int, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultAdminPageSize
+	}
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
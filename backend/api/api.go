@@ -11,6 +11,8 @@ import (
 	"encore.dev/beta/auth"
 	"encore.dev/storage/sqldb"
 	"encore.dev/types/uuid"
+
+	authservice "encore.app/backend/auth"
 )
 
 // Define a database named 'recipe', using the database migrations
@@ -33,18 +35,63 @@ type Recipe struct {
 	CookTimeMinutes int16    `json:"cook_time_minutes"`
 	Tags            []string `json:"tags"`
 	ImageUrl        string   `json:"image_url"`
+
+	// Servings, yield and times. SaveRecipe keeps the stored value of any of
+	// them the client omits.
+	Servings         *int16  `json:"servings"`
+	Yield            *string `json:"yield"` // what the recipe makes, e.g. "2 loaves"
+	PrepTimeMinutes  *int16  `json:"prep_time_minutes"`
+	RestTimeMinutes  *int16  `json:"rest_time_minutes"` // resting, rising, chilling, marinating
+	TotalTimeMinutes *int16  `json:"total_time_minutes"`
+
+	// HouseholdId is the household the recipe is shared with, or "" if it
+	// is not shared. SaveRecipe keeps the stored household when it is omitted.
+	HouseholdId *string `json:"household_id"`
+
+	// CookStages are the ordered cooking stages. CookTempDegF and
+	// CookTimeMinutes are derived from them.
+	CookStages []*CookStage `json:"cook_stages"`
+
+	// Caller-specific state, only populated by GetRecipe for authenticated callers.
+	IsFavorite   bool   `json:"is_favorite"`
+	LastCookedOn string `json:"last_cooked_on"`
+
+	// Rendered holds the Markdown fields as sanitised HTML and plain text,
+	// only populated by GetRecipe.
+	Rendered *RenderedRecipe `json:"rendered,omitempty"`
+
+	// Redirect is set by GetRecipe when the recipe has moved, i.e. it was
+	// found under an old username or slug.
+	Redirect *Redirect `json:"redirect,omitempty"`
 }
 
 type RecipeCard struct {
-	Id       string   `json:"id"`
-	Username string   `json:"username"`
-	Slug     string   `json:"slug"`
-	Title    string   `json:"title"`
-	Tags     []string `json:"tags"`
+	Id            string   `json:"id"`
+	Username      string   `json:"username"`
+	Slug          string   `json:"slug"`
+	Title         string   `json:"title"`
+	Tags          []string `json:"tags"`
+	AverageRating float64  `json:"average_rating"`
+	RatingCount   int      `json:"rating_count"`
 }
 
 type RecipeListResponse struct {
 	Recipes []*RecipeCard
+	// Redirect is set when the listing was found under an old username.
+	Redirect *Redirect `json:"redirect,omitempty"`
+}
+
+// Redirect tells the client the canonical location of a resource that was
+// requested by an old URL.
+type Redirect struct {
+	Username string `json:"username"`
+	Slug     string `json:"slug,omitempty"`
+}
+
+type RecipeListParams struct {
+	// Household limits the listing to recipes shared with the given household.
+	// The caller must be a member of that household.
+	Household string `query:"household"`
 }
 
 type FileUpload struct {
@@ -55,19 +102,27 @@ type FileUpload struct {
 
 type FileUploadRequest struct {
 	Files []FileUpload `json:"files"`
+	// Draft keeps the extracted recipe as a draft for review instead of saving it.
+	Draft bool `json:"draft"`
 }
 
 type GenerateFromTextRequest struct {
-	Text string `json:"text"`
+	Text  string `json:"text"`
+	Draft bool   `json:"draft"`
 }
 
 type GenerateRecipeResponse struct {
 	Username string `json:"username"`
 	Slug     string `json:"slug"`
+	// Draft is set instead of Username and Slug when a draft was requested.
+	Draft *RecipeDraft `json:"draft,omitempty"`
 }
 
 type IsSlugAvailableRequest struct {
 	Slug string `json:"slug"`
+	// RecipeId is the recipe being edited, if any. Its own current and
+	// previous slugs are available to it.
+	RecipeId string `json:"recipe_id"`
 }
 type IsSlugAvailableResponse struct {
 	Available bool `json:"available"`
@@ -77,12 +132,22 @@ type IsUsernameAvailableRequest struct {
 	Username string `json:"username"`
 }
 type IsUsernameAvailableResponse struct {
-	Available bool `json:"available"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason"` // why the username is unavailable, if it is
 }
 
 type Profile struct {
 	Id       string `json:"id"`
 	Username string `json:"username"`
+
+	// Profile details. SaveProfile leaves the stored value of any detail
+	// the client omits unchanged.
+	DisplayName *string  `json:"display_name"`
+	Bio         *string  `json:"bio"`
+	AvatarUrl   *string  `json:"avatar_url"`
+	Links       []string `json:"links"`
+
+	Households []*HouseholdMembership `json:"households"`
 }
 
 type ProfileRecipesResponse struct {
@@ -94,12 +159,13 @@ type ProfileRecipe struct {
 	RecipeCount int    `json:"recipe_count"`
 }
 
-//encore:api public method=GET path=/api/top-profiles
+//encore:api public method=GET path=/api/top-profiles tag:read
 func GetTopProfiles(ctx context.Context) (*ProfileRecipesResponse, error) {
 	rows, err := db.Query(ctx, `
 		SELECT p.username, COUNT(r.id) AS recipe_count
 		FROM recipe r
 		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE `+recipeListableCondition+`
 		GROUP BY p.username
 		ORDER BY recipe_count DESC
 	`)
@@ -125,7 +191,7 @@ func GetTopProfiles(ctx context.Context) (*ProfileRecipesResponse, error) {
 	return &ProfileRecipesResponse{ProfileRecipes: profileRecipes}, nil
 }
 
-//encore:api auth method=GET path=/api/profile
+//encore:api auth method=GET path=/api/profile tag:read
 func GetMyProfile(ctx context.Context) (*Profile, error) {
 	authResult, authBool := auth.UserID()
 	if !authBool {
@@ -133,19 +199,38 @@ func GetMyProfile(ctx context.Context) (*Profile, error) {
 		return nil, err
 	}
 
-	pro := &Profile{Id: string(authResult)}
+	pro := &Profile{Id: string(authResult), DisplayName: new(string), Bio: new(string), AvatarUrl: new(string)}
 
 	err := db.QueryRow(ctx, `
-	SELECT username
+	SELECT username, display_name, bio, avatar_url, links
 	FROM profile
 	WHERE id = $1
-	`, pro.Id).Scan(&pro.Username)
+	`, pro.Id).Scan(&pro.Username, pro.DisplayName, pro.Bio, pro.AvatarUrl, &pro.Links)
 
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			pro.Username = ""
-			return pro, nil
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// Create the profile on first login. API tokens only exist for
+		// existing profiles, so only signed-in sessions get here.
+		created := false
+		if _, err := authservice.RequireInteractiveUser(); err == nil {
+			if created, err = createNewProfile(ctx, pro.Id); err != nil {
+				return nil, err
+			}
 		}
+		if created {
+			return GetMyProfile(ctx)
+		}
+
+		// The client asks the user to pick a username and calls SaveProfile.
+		pro.Username = ""
+		return pro, nil
+	}
+
+	pro.Households, err = getHouseholdMemberships(ctx, pro.Id)
+	if err != nil {
 		return nil, err
 	}
 
@@ -154,36 +239,98 @@ func GetMyProfile(ctx context.Context) (*Profile, error) {
 
 //encore:api auth method=POST path=/api/profile
 func SaveProfile(ctx context.Context, pro *Profile) (*Profile, error) {
-	authResult, authBool := auth.UserID()
-	if !authBool || string(authResult) != pro.Id {
+	profileId, err := authservice.RequireInteractiveUser()
+	if err != nil {
+		return nil, err
+	}
+	if profileId != pro.Id {
 		err := fmt.Errorf("not authorized")
 		return nil, err
 	}
 
+	// On first login the client can leave the username empty to have the
+	// profile created from the sign-in details. A username the client sends
+	// counts as chosen by the user.
+	usernameChosen := pro.Username != ""
+	if !usernameChosen {
+		if err := fillNewProfile(ctx, pro); err != nil {
+			return nil, err
+		}
+	}
+
+	// Usernames chosen before the current rules stay valid until changed.
+	currentUsername, err := getUsername(ctx, pro.Id)
+	if err != nil {
+		return nil, err
+	}
+	if pro.Username != currentUsername {
+		if err := validateUsername(pro.Username); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateProfile(pro); err != nil {
+		return nil, err
+	}
+
+	taken, err := checkUsernameTakenByOther(ctx, pro.Username, pro.Id)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, fmt.Errorf("username is not available")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Keep the old username so existing recipe links can be redirected.
+	if err := recordUsernameChange(ctx, tx, pro.Id, pro.Username); err != nil {
+		return nil, err
+	}
+
 	// Save the profile to the database.
 	// If the profile already exists (i.e. CONFLICT), we update the profile info.
-	_, err := db.Exec(ctx, `
-		INSERT INTO profile (id, username)
-		VALUES ($1, $2)
-		ON CONFLICT (id) DO UPDATE SET username=$2
-	`, pro.Id, pro.Username)
+	// Details the client omitted keep their stored value.
+	_, err = tx.Exec(ctx, `
+		INSERT INTO profile (id, username, display_name, bio, avatar_url, links, username_chosen)
+		VALUES ($1, $2, COALESCE($3, ''), COALESCE($4, ''), COALESCE($5, ''), COALESCE($6::TEXT[], '{}'), $7)
+		ON CONFLICT (id) DO UPDATE SET username=$2, display_name=COALESCE($3, profile.display_name), bio=COALESCE($4, profile.bio),
+		                               avatar_url=COALESCE($5, profile.avatar_url), links=COALESCE($6::TEXT[], profile.links),
+		                               username_chosen=profile.username_chosen OR $7
+	`, pro.Id, pro.Username, pro.DisplayName, pro.Bio, pro.AvatarUrl, pro.Links, usernameChosen)
 
 	// If there was an error saving to the database, then we return that error.
 	if err != nil {
 		return nil, err
 	}
 
-	return pro, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetMyProfile(ctx)
 }
 
-//encore:api public method=POST path=/api/username/available
+//encore:api public method=POST path=/api/username/available tag:read
 func CheckIfUsernameIsAvailable(ctx context.Context, req IsUsernameAvailableRequest) (IsUsernameAvailableResponse, error) {
+	if err := validateUsername(req.Username); err != nil {
+		return IsUsernameAvailableResponse{Available: false, Reason: err.Error()}, nil
+	}
+
 	exists, err := checkUsernameExists(ctx, req.Username)
 	if err != nil {
 		return IsUsernameAvailableResponse{}, err
 	}
 
-	return IsUsernameAvailableResponse{Available: !exists}, nil
+	if exists {
+		return IsUsernameAvailableResponse{Available: false, Reason: "username is already taken"}, nil
+	}
+
+	return IsUsernameAvailableResponse{Available: true}, nil
 }
 
 func checkUsernameExists(ctx context.Context, username string) (bool, error) {
@@ -193,6 +340,10 @@ func checkUsernameExists(ctx context.Context, username string) (bool, error) {
 			SELECT 1
 			FROM profile
 			WHERE LOWER(username) = LOWER($1)
+		) OR EXISTS (
+			SELECT 1
+			FROM username_history
+			WHERE LOWER(username) = LOWER($1)
 		)
 	`, username).Scan(&exists)
 
@@ -203,43 +354,81 @@ func checkUsernameExists(ctx context.Context, username string) (bool, error) {
 	return exists, nil
 }
 
-//encore:api public method=GET path=/api/recipes
-func GetAllRecipes(ctx context.Context) (*RecipeListResponse, error) {
-	rows, err := db.Query(ctx, `
-		SELECT r.id, p.username, r.slug, r.title, r.tags
+//encore:api public method=GET path=/api/recipes tag:read
+func GetAllRecipes(ctx context.Context, params *RecipeListParams) (*RecipeListResponse, error) {
+	if err := checkHouseholdScope(ctx, params.Household); err != nil {
+		return nil, err
+	}
+
+	recipeCards, err := queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
 		FROM recipe r
 		INNER JOIN profile p ON r.profile_id = p.id
-	`)
+		WHERE `+recipeListableCondition+` AND ($1 = '' OR r.household_id = $1)
+	`, params.Household)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var recipeCards []*RecipeCard
-	for rows.Next() {
-		rc := &RecipeCard{}
-		if err := rows.Scan(&rc.Id, &rc.Username, &rc.Slug, &rc.Title, &rc.Tags); err != nil {
-			return nil, err
-		}
-		recipeCards = append(recipeCards, rc)
+	return &RecipeListResponse{Recipes: recipeCards}, nil
+}
+
+//encore:api public method=GET path=/api/recipes/:username tag:read
+func GetRecipesByProfileId(ctx context.Context, username string, params *RecipeListParams) (*RecipeListResponse, error) {
+	if err := checkHouseholdScope(ctx, params.Household); err != nil {
+		return nil, err
 	}
 
-	// Check if there were any errors during iteration.
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	recipeCards, err := queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE `+recipeListableCondition+` AND LOWER(p.username) = LOWER($1) AND ($2 = '' OR r.household_id = $2)
+	`, username, params.Household)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty listing may mean the profile has since changed its username.
+	if len(recipeCards) == 0 {
+		canonical, err := resolveOldUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if canonical != "" {
+			response, err := GetRecipesByProfileId(ctx, canonical, params)
+			if err != nil {
+				return nil, err
+			}
+			response.Redirect = &Redirect{Username: canonical}
+			return response, nil
+		}
 	}
 
 	return &RecipeListResponse{Recipes: recipeCards}, nil
 }
 
-//encore:api public method=GET path=/api/recipes/:username
-func GetRecipesByProfileId(ctx context.Context, username string) (*RecipeListResponse, error) {
-	rows, err := db.Query(ctx, `
-		SELECT r.id, p.username, r.slug, r.title, r.tags
-		FROM recipe r
-		INNER JOIN profile p ON r.profile_id = p.id
-		WHERE LOWER(p.username) = LOWER($1)
-	`, username)
+// recipeCardColumns is the select list for a RecipeCard, in the order
+// expected by recipeCardDest. Queries using it must alias the recipe table
+// as r and the profile table as p.
+const recipeCardColumns = `r.id, p.username, r.slug, r.title, r.tags,
+		COALESCE((SELECT AVG(rating)::float8 FROM recipe_rating WHERE recipe_id = r.id), 0),
+		(SELECT COUNT(*) FROM recipe_rating WHERE recipe_id = r.id)`
+
+// recipeListableCondition restricts a query to recipes that may appear in
+// public listings. Queries using it must alias the recipe table as r and the
+// profile table as p.
+const recipeListableCondition = `NOT r.hidden AND NOT p.hidden AND p.banned_at IS NULL`
+
+// recipeCardDest returns the scan destinations matching recipeCardColumns.
+func recipeCardDest(rc *RecipeCard) []interface{} {
+	return []interface{}{&rc.Id, &rc.Username, &rc.Slug, &rc.Title, &rc.Tags, &rc.AverageRating, &rc.RatingCount}
+}
+
+// queryRecipeCards runs a query selecting recipeCardColumns and collects
+// the rows into recipe cards.
+func queryRecipeCards(ctx context.Context, query string, args ...interface{}) ([]*RecipeCard, error) {
+	rows, err := db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -248,7 +437,7 @@ func GetRecipesByProfileId(ctx context.Context, username string) (*RecipeListRes
 	var recipeCards []*RecipeCard
 	for rows.Next() {
 		rc := &RecipeCard{}
-		if err := rows.Scan(&rc.Id, &rc.Username, &rc.Slug, &rc.Title, &rc.Tags); err != nil {
+		if err := rows.Scan(recipeCardDest(rc)...); err != nil {
 			return nil, err
 		}
 		recipeCards = append(recipeCards, rc)
@@ -259,39 +448,138 @@ func GetRecipesByProfileId(ctx context.Context, username string) (*RecipeListRes
 		return nil, fmt.Errorf("could not iterate over rows: %v", err)
 	}
 
-	return &RecipeListResponse{Recipes: recipeCards}, nil
+	return recipeCards, nil
 }
 
-//encore:api public method=GET path=/api/recipes/:username/:slug
+// checkHouseholdScope verifies the caller is a member of the household a
+// listing is scoped to. An empty household means no scope.
+func checkHouseholdScope(ctx context.Context, householdId string) error {
+	if householdId == "" {
+		return nil
+	}
+
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	return requireHouseholdRole(ctx, householdId, string(authResult), HouseholdRoleViewer)
+}
+
+//encore:api public method=GET path=/api/recipes/:username/:slug tag:read
 func GetRecipe(ctx context.Context, username string, slug string) (*Recipe, error) {
-	recipe := &Recipe{Slug: slug}
+	recipe, err := getRecipeByUsernameAndSlug(ctx, username, slug)
+	if errors.Is(err, errRecipeNotFound) {
+		recipe, err = getMovedRecipe(ctx, username, slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Hidden recipes and recipes of hidden or banned profiles are only shown to their owner and admins.
+	listable, err := isRecipeListable(ctx, recipe.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !listable {
+		authResult, _ := auth.UserID()
+		if string(authResult) != recipe.ProfileId && !isAdmin() {
+			return nil, errRecipeNotFound
+		}
+	}
+
+	if err := loadRecipeUserState(ctx, recipe); err != nil {
+		return nil, err
+	}
+	recipe.Rendered = renderRecipe(recipe)
+
+	return recipe, nil
+}
+
+var errRecipeNotFound = errors.New("recipe not found")
+
+// getMovedRecipe looks up a recipe by a URL that may use the profile's old
+// username, the recipe's old slug, or both. The returned recipe carries a
+// redirect to its current URL.
+func getMovedRecipe(ctx context.Context, username string, slug string) (*Recipe, error) {
+	canonicalUsername, err := resolveOldUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if canonicalUsername == "" {
+		canonicalUsername = username
+	} else {
+		recipe, err := getRecipeByUsernameAndSlug(ctx, canonicalUsername, slug)
+		if err == nil {
+			recipe.Redirect = &Redirect{Username: canonicalUsername, Slug: recipe.Slug}
+			return recipe, nil
+		}
+		if !errors.Is(err, errRecipeNotFound) {
+			return nil, err
+		}
+	}
+
+	currentSlug, err := resolveOldSlug(ctx, canonicalUsername, slug)
+	if err != nil {
+		return nil, err
+	}
+	if currentSlug == "" {
+		return nil, errRecipeNotFound
+	}
+
+	recipe, err := getRecipeByUsernameAndSlug(ctx, canonicalUsername, currentSlug)
+	if err != nil {
+		return nil, err
+	}
+	recipe.Redirect = &Redirect{Username: canonicalUsername, Slug: recipe.Slug}
+
+	return recipe, nil
+}
+
+func getRecipeByUsernameAndSlug(ctx context.Context, username string, slug string) (*Recipe, error) {
+	recipe := &Recipe{Servings: new(int16), Yield: new(string), PrepTimeMinutes: new(int16), RestTimeMinutes: new(int16), TotalTimeMinutes: new(int16)}
+	var householdId string
 
 	// Use a JOIN to get the profile_id by username and retrieve recipe details in one query
 	err := db.QueryRow(ctx, `
-		SELECT r.id, r.profile_id, r.title, r.ingredients, r.instructions, r.notes, 
-		       r.cook_temp_deg_f, r.cook_time_minutes, r.tags, r.image_url
+		SELECT r.id, r.profile_id, r.slug, r.title, r.ingredients, r.instructions, r.notes, 
+		       r.cook_temp_deg_f, r.cook_time_minutes, r.servings, r.yield, r.prep_time_minutes, r.rest_time_minutes,
+		       r.total_time_minutes, r.tags, r.image_url, COALESCE(r.household_id, '')
 		FROM recipe r
 		INNER JOIN profile p ON r.profile_id = p.id
 		WHERE LOWER(p.username) = LOWER($1) AND LOWER(r.slug) = LOWER($2)
 	`, username, slug).Scan(
 		&recipe.Id,
 		&recipe.ProfileId,
+		&recipe.Slug,
 		&recipe.Title,
 		&recipe.Ingredients,
 		&recipe.Instructions,
 		&recipe.Notes,
 		&recipe.CookTempDegF,
 		&recipe.CookTimeMinutes,
+		recipe.Servings,
+		recipe.Yield,
+		recipe.PrepTimeMinutes,
+		recipe.RestTimeMinutes,
+		recipe.TotalTimeMinutes,
 		&recipe.Tags,
 		&recipe.ImageUrl,
+		&householdId,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("recipe not found")
+			return nil, errRecipeNotFound
 		}
 		return nil, err
 	}
+	recipe.HouseholdId = &householdId
+
+	recipe.CookStages, err = loadCookStages(ctx, recipe.Id)
+	if err != nil {
+		return nil, err
+	}
 
 	return recipe, nil
 }
@@ -299,26 +587,117 @@ func GetRecipe(ctx context.Context, username string, slug string) (*Recipe, erro
 //encore:api auth method=POST path=/api/recipes
 func SaveRecipe(ctx context.Context, recipe *Recipe) (*Recipe, error) {
 	authResult, authBool := auth.UserID()
-	if !authBool || string(authResult) != recipe.ProfileId {
+	if !authBool {
 		err := fmt.Errorf("not authorized")
 		return nil, err
 	}
 
-	_, err := db.Exec(ctx, `
-		INSERT INTO recipe (id, profile_id, slug, title, ingredients, instructions, notes, cook_temp_deg_f, cook_time_minutes, tags, image_url)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		ON CONFLICT (id) DO UPDATE SET profile_id=$2, slug=$3, title=$4, ingredients=$5, instructions=$6, notes=$7, cook_temp_deg_f=$8, cook_time_minutes=$9, tags=$10, image_url=$11
-	`, recipe.Id, recipe.ProfileId, recipe.Slug, recipe.Title, recipe.Ingredients, recipe.Instructions, recipe.Notes, recipe.CookTempDegF, recipe.CookTimeMinutes, recipe.Tags, recipe.ImageUrl)
-
-	// If there was an error saving to the database, then we return that error.
+	tx, err := db.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	if err := saveRecipe(ctx, tx, string(authResult), recipe); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
 	// Otherwise, we return the recipe to indicate that the save was successful.
 	return recipe, nil
 }
 
+// saveRecipe checks the profile may save the recipe and then creates or
+// updates it within the transaction.
+func saveRecipe(ctx context.Context, tx *sqldb.Tx, profileId string, recipe *Recipe) error {
+	if err := checkNotBanned(ctx, profileId); err != nil {
+		return err
+	}
+
+	if err := checkCanSaveRecipe(ctx, recipe, profileId); err != nil {
+		return err
+	}
+
+	if err := resolveCookStages(ctx, recipe); err != nil {
+		return err
+	}
+
+	// Keep the old slug so existing links to the recipe can be redirected.
+	if err := recordSlugChange(ctx, tx, recipe); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO recipe (id, profile_id, slug, title, ingredients, instructions, notes, cook_temp_deg_f, cook_time_minutes, tags, image_url, household_id,
+		                    servings, yield, prep_time_minutes, rest_time_minutes, total_time_minutes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''),
+		        COALESCE($13::SMALLINT, 0), COALESCE($14::TEXT, ''), COALESCE($15::SMALLINT, 0), COALESCE($16::SMALLINT, 0), COALESCE($17::SMALLINT, 0))
+		ON CONFLICT (id) DO UPDATE SET profile_id=$2, slug=$3, title=$4, ingredients=$5, instructions=$6, notes=$7, cook_temp_deg_f=$8, cook_time_minutes=$9, tags=$10, image_url=$11, household_id=NULLIF($12, ''),
+		                               servings=COALESCE($13::SMALLINT, recipe.servings), yield=COALESCE($14::TEXT, recipe.yield), prep_time_minutes=COALESCE($15::SMALLINT, recipe.prep_time_minutes),
+		                               rest_time_minutes=COALESCE($16::SMALLINT, recipe.rest_time_minutes), total_time_minutes=COALESCE($17::SMALLINT, recipe.total_time_minutes), updated_at=NOW()
+	`, recipe.Id, recipe.ProfileId, recipe.Slug, recipe.Title, recipe.Ingredients, recipe.Instructions, recipe.Notes, recipe.CookTempDegF, recipe.CookTimeMinutes, recipe.Tags, recipe.ImageUrl, *recipe.HouseholdId,
+		recipe.Servings, recipe.Yield, recipe.PrepTimeMinutes, recipe.RestTimeMinutes, recipe.TotalTimeMinutes)
+
+	// If there was an error saving to the database, then we return that error.
+	if err != nil {
+		return err
+	}
+
+	return saveCookStages(ctx, tx, recipe.Id, recipe.CookStages)
+}
+
+// checkCanSaveRecipe verifies the caller may create or update the recipe.
+// New recipes must belong to the caller. Existing recipes can be edited by
+// their owner, or by an editor of the household the recipe is shared with.
+func checkCanSaveRecipe(ctx context.Context, recipe *Recipe, profileId string) error {
+	var existingProfileId, existingHouseholdId string
+	err := db.QueryRow(ctx, `
+		SELECT profile_id, COALESCE(household_id, '')
+		FROM recipe
+		WHERE id = $1
+	`, recipe.Id).Scan(&existingProfileId, &existingHouseholdId)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error retrieving recipe: %w", err)
+	}
+
+	// Clients that predate households do not send the household, so an
+	// omitted household keeps the recipe where it is.
+	if recipe.HouseholdId == nil {
+		recipe.HouseholdId = &existingHouseholdId
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		if recipe.ProfileId != profileId {
+			return fmt.Errorf("not authorized")
+		}
+	} else {
+		if recipe.ProfileId != existingProfileId {
+			return fmt.Errorf("not authorized")
+		}
+		if existingProfileId != profileId {
+			// Household editors may change the recipe but not move it out of the household.
+			if existingHouseholdId == "" || *recipe.HouseholdId != existingHouseholdId {
+				return fmt.Errorf("not authorized")
+			}
+			if err := requireHouseholdRole(ctx, existingHouseholdId, profileId, HouseholdRoleEditor); err != nil {
+				return err
+			}
+		}
+	}
+
+	if *recipe.HouseholdId != "" && *recipe.HouseholdId != existingHouseholdId {
+		if err := requireHouseholdRole(ctx, *recipe.HouseholdId, profileId, HouseholdRoleEditor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 //encore:api auth method=DELETE path=/api/recipes/:id
 func DeleteRecipe(ctx context.Context, id string) error {
 	authResult, authBool := auth.UserID()
@@ -326,12 +705,12 @@ func DeleteRecipe(ctx context.Context, id string) error {
 		return fmt.Errorf("not authorized")
 	}
 
-	var recipeProfileId string
+	var recipeProfileId, recipeHouseholdId string
 	err := db.QueryRow(ctx, `
-		SELECT profile_id
+		SELECT profile_id, COALESCE(household_id, '')
 		FROM recipe
 		WHERE id = $1
-	`, id).Scan(&recipeProfileId)
+	`, id).Scan(&recipeProfileId, &recipeHouseholdId)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -340,8 +719,14 @@ func DeleteRecipe(ctx context.Context, id string) error {
 		return fmt.Errorf("error retrieving recipe: %w", err)
 	}
 
+	// Household owners can also remove recipes shared with their household.
 	if recipeProfileId != string(authResult) {
-		return fmt.Errorf("not authorized to delete this recipe")
+		if recipeHouseholdId == "" {
+			return fmt.Errorf("not authorized to delete this recipe")
+		}
+		if err := requireHouseholdRole(ctx, recipeHouseholdId, string(authResult), HouseholdRoleOwner); err != nil {
+			return fmt.Errorf("not authorized to delete this recipe")
+		}
 	}
 
 	_, err = db.Exec(ctx, `DELETE FROM recipe WHERE id = $1`, id)
@@ -361,6 +746,10 @@ func CopyRecipe(ctx context.Context, id string) (*GenerateRecipeResponse, error)
 	}
 
 	authProfileId := string(authResult)
+	if err := checkNotBanned(ctx, authProfileId); err != nil {
+		return nil, err
+	}
+
 	newRecipeId, err := uuid.NewV4()
 	if err != nil {
 		return nil, fmt.Errorf("error generating new recipe ID: %w", err)
@@ -373,7 +762,7 @@ func CopyRecipe(ctx context.Context, id string) (*GenerateRecipeResponse, error)
 	}
 
 	var slug string
-	slug, err = createUniqueSlug(ctx, title, string(authResult))
+	slug, err = createUniqueSlug(ctx, db, title, string(authResult))
 	if err != nil {
 		return nil, fmt.Errorf("error generating slug: %w", err)
 	}
@@ -381,7 +770,8 @@ func CopyRecipe(ctx context.Context, id string) (*GenerateRecipeResponse, error)
 	// Step 3: Perform the recipe duplication in a single query
 	_, err = db.Exec(ctx, `
         INSERT INTO recipe (
-            id, profile_id, slug, title, ingredients, instructions, notes, cook_temp_deg_f, cook_time_minutes, tags, image_url
+            id, profile_id, slug, title, ingredients, instructions, notes, cook_temp_deg_f, cook_time_minutes, tags, image_url, copied_from_id,
+            servings, yield, prep_time_minutes, rest_time_minutes, total_time_minutes
         )
         SELECT 
             $1, -- New UUID
@@ -394,7 +784,13 @@ func CopyRecipe(ctx context.Context, id string) (*GenerateRecipeResponse, error)
             cook_temp_deg_f, 
             cook_time_minutes, 
             tags,
-			image_url
+			image_url,
+			id,
+            servings,
+            yield,
+            prep_time_minutes,
+            rest_time_minutes,
+            total_time_minutes
         FROM recipe
         WHERE id = $4
     `, newRecipeId.String(), authProfileId, slug, id)
@@ -402,6 +798,16 @@ func CopyRecipe(ctx context.Context, id string) (*GenerateRecipeResponse, error)
 		return nil, fmt.Errorf("failed to copy recipe in database: %w", err)
 	}
 
+	_, err = db.Exec(ctx, `
+		INSERT INTO recipe_cook_stage (recipe_id, position, method, temp_deg_f, duration_minutes, note)
+		SELECT $1, position, method, temp_deg_f, duration_minutes, note
+		FROM recipe_cook_stage
+		WHERE recipe_id = $2
+	`, newRecipeId.String(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy recipe cook stages: %w", err)
+	}
+
 	response, err := getAddRecipeResponse(ctx, newRecipeId.String())
 	if err != nil {
 		return nil, fmt.Errorf("error generating recipe response: %w", err)
@@ -417,34 +823,36 @@ func GenerateFromImages(ctx context.Context, req FileUploadRequest) (*GenerateRe
 		return nil, fmt.Errorf("not authorized")
 	}
 
-	recipe, err := AnalyzeImageToRecipe(ctx, req.Files)
-	if err != nil {
-		return nil, fmt.Errorf("error analyzing images: %w", err)
+	if err := checkNotBanned(ctx, string(authResult)); err != nil {
+		return nil, err
 	}
 
-	recipe.ProfileId = string(authResult)
-	recipeId, err := uuid.NewV4()
+	files, err := preprocessUploads(req.Files)
 	if err != nil {
-		return nil, fmt.Errorf("error generating uuid: %w", err)
+		return nil, err
 	}
-	recipe.Id = recipeId.String()
 
-	recipe.Slug, err = createUniqueSlug(ctx, recipe.Title, string(authResult))
+	tags, err := getTagVocabulary(ctx, string(authResult))
 	if err != nil {
-		return nil, fmt.Errorf("error generating slug: %w", err)
+		return nil, err
 	}
 
-	savedRecipe, err := SaveRecipe(ctx, recipe)
+	usageId, err := startAiUsage(ctx, string(authResult), aiImportKindImage)
 	if err != nil {
-		return nil, fmt.Errorf("error saving recipe to database: %w", err)
+		return nil, err
 	}
 
-	response, err := getAddRecipeResponse(ctx, savedRecipe.Id)
+	recipe, usage, err := AnalyzeImageToRecipe(ctx, files, tags)
+	finishAiUsage(ctx, usageId, usage, err)
 	if err != nil {
-		return nil, fmt.Errorf("error generating recipe response: %w", err)
+		return nil, fmt.Errorf("error analyzing images: %w", err)
 	}
 
-	return response, nil
+	if req.Draft {
+		return saveRecipeDraft(ctx, string(authResult), aiImportKindImage, recipe)
+	}
+
+	return saveGeneratedRecipe(ctx, string(authResult), recipe)
 }
 
 //encore:api auth method=POST path=/api/add-recipe/from-text
@@ -454,30 +862,51 @@ func GenerateFromText(ctx context.Context, req GenerateFromTextRequest) (*Genera
 		return nil, fmt.Errorf("not authorized")
 	}
 
-	recipe, err := AnalyzeTextToRecipe(ctx, req.Text)
+	if err := checkNotBanned(ctx, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	tags, err := getTagVocabulary(ctx, string(authResult))
 	if err != nil {
-		return nil, fmt.Errorf("error analyzing text: %w", err)
+		return nil, err
 	}
 
-	recipe.ProfileId = string(authResult)
-	recipeId, err := uuid.NewV4()
+	usageId, err := startAiUsage(ctx, string(authResult), aiImportKindText)
 	if err != nil {
-		return nil, fmt.Errorf("error generating uuid: %w", err)
+		return nil, err
 	}
-	recipe.Id = recipeId.String()
 
-	recipe.Slug, err = createUniqueSlug(ctx, recipe.Title, string(authResult))
+	recipe, usage, err := AnalyzeTextToRecipe(ctx, req.Text, tags)
+	finishAiUsage(ctx, usageId, usage, err)
 	if err != nil {
-		return nil, fmt.Errorf("error generating slug: %w", err)
+		return nil, fmt.Errorf("error analyzing text: %w", err)
+	}
+
+	if req.Draft {
+		return saveRecipeDraft(ctx, string(authResult), aiImportKindText, recipe)
 	}
 
-	// Save the recipe
-	savedRecipe, err := SaveRecipe(ctx, recipe)
+	return saveGeneratedRecipe(ctx, string(authResult), recipe)
+}
+
+// saveGeneratedRecipe saves a recipe extracted by the AI import as a new
+// recipe owned by the profile.
+func saveGeneratedRecipe(ctx context.Context, profileId string, recipe *Recipe) (*GenerateRecipeResponse, error) {
+	tx, err := db.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error saving recipe to database: %w", err)
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	response, err := getAddRecipeResponse(ctx, savedRecipe.Id)
+	if err := createGeneratedRecipe(ctx, tx, profileId, recipe); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	response, err := getAddRecipeResponse(ctx, recipe.Id)
 	if err != nil {
 		return nil, fmt.Errorf("error generating recipe response: %w", err)
 	}
@@ -485,6 +914,28 @@ func GenerateFromText(ctx context.Context, req GenerateFromTextRequest) (*Genera
 	return response, nil
 }
 
+// createGeneratedRecipe assigns a new ID and a unique slug to a recipe
+// extracted by the AI import and saves it within the transaction.
+func createGeneratedRecipe(ctx context.Context, tx *sqldb.Tx, profileId string, recipe *Recipe) error {
+	recipe.ProfileId = profileId
+	recipeId, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating uuid: %w", err)
+	}
+	recipe.Id = recipeId.String()
+
+	recipe.Slug, err = createUniqueSlug(ctx, tx, recipe.Title, profileId)
+	if err != nil {
+		return fmt.Errorf("error generating slug: %w", err)
+	}
+
+	if err := saveRecipe(ctx, tx, profileId, recipe); err != nil {
+		return fmt.Errorf("error saving recipe to database: %w", err)
+	}
+
+	return nil
+}
+
 func getAddRecipeResponse(ctx context.Context, recipeId string) (*GenerateRecipeResponse, error) {
 	recipe := &GenerateRecipeResponse{}
 
@@ -505,7 +956,7 @@ func getAddRecipeResponse(ctx context.Context, recipeId string) (*GenerateRecipe
 	return recipe, nil
 }
 
-//encore:api auth method=POST path=/api/slug/available
+//encore:api auth method=POST path=/api/slug/available tag:read
 func CheckIfSlugIsAvailable(ctx context.Context, req IsSlugAvailableRequest) (IsSlugAvailableResponse, error) {
 	authResult, authBool := auth.UserID()
 	if !authBool {
@@ -513,7 +964,7 @@ func CheckIfSlugIsAvailable(ctx context.Context, req IsSlugAvailableRequest) (Is
 		return IsSlugAvailableResponse{}, err
 	}
 
-	exists, err := checkSlugExists(ctx, req.Slug, string(authResult))
+	exists, err := checkSlugExists(ctx, db, req.Slug, string(authResult), req.RecipeId)
 	if err != nil {
 		return IsSlugAvailableResponse{}, err
 	}
@@ -521,13 +972,18 @@ func CheckIfSlugIsAvailable(ctx context.Context, req IsSlugAvailableRequest) (Is
 	return IsSlugAvailableResponse{Available: !exists}, nil
 }
 
-func createUniqueSlug(ctx context.Context, title string, profileId string) (string, error) {
+// queryer runs single-row queries on the database or within a transaction.
+type queryer interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sqldb.Row
+}
+
+func createUniqueSlug(ctx context.Context, q queryer, title string, profileId string) (string, error) {
 	// Step 1: Slugify the title
 	reg := regexp.MustCompile(`[^a-z0-9]+`)
 	slugCandidate := reg.ReplaceAllString(strings.ToLower(title), "-")
 
 	// Step 2: Check if the plain slug already exists
-	exists, err := checkSlugExists(ctx, slugCandidate, profileId)
+	exists, err := checkSlugExists(ctx, q, slugCandidate, profileId, "")
 	if err != nil {
 		return "", err
 	}
@@ -537,11 +993,15 @@ func createUniqueSlug(ctx context.Context, title string, profileId string) (stri
 
 	// Step 3: Query for the highest suffix if the plain slug exists
 	var maxSuffix int
-	err = db.QueryRow(ctx, `
+	err = q.QueryRow(ctx, `
 	WITH existing_slugs AS (
 		SELECT slug 
 		FROM recipe 
 		WHERE slug = $1 OR slug LIKE $2
+		UNION ALL
+		SELECT slug
+		FROM recipe_slug_history
+		WHERE slug = $1 OR slug LIKE $2
 	)
 	SELECT COALESCE(MAX(CAST(NULLIF(SUBSTRING(slug FROM LENGTH($1) + 2), '') AS INT)), 0) AS max_suffix
 	FROM existing_slugs
@@ -554,15 +1014,22 @@ func createUniqueSlug(ctx context.Context, title string, profileId string) (stri
 	return fmt.Sprintf("%s-%d", slugCandidate, maxSuffix+1), nil
 }
 
-func checkSlugExists(ctx context.Context, slug string, profileId string) (bool, error) {
+// checkSlugExists reports whether any of the profile's recipes other than
+// exceptRecipeId uses or used the slug. This matches what recordSlugChange
+// allows: a recipe may switch back to one of its own previous slugs.
+func checkSlugExists(ctx context.Context, q queryer, slug string, profileId string, exceptRecipeId string) (bool, error) {
 	var exists bool
-	err := db.QueryRow(ctx, `
+	err := q.QueryRow(ctx, `
 		SELECT EXISTS (
 			SELECT 1
 			FROM recipe
-			WHERE LOWER(slug) = LOWER($1) AND profile_id = $2
+			WHERE LOWER(slug) = LOWER($1) AND profile_id = $2 AND id <> $3
+		) OR EXISTS (
+			SELECT 1
+			FROM recipe_slug_history
+			WHERE LOWER(slug) = LOWER($1) AND profile_id = $2 AND recipe_id <> $3
 		)
-	`, slug, profileId).Scan(&exists)
+	`, slug, profileId, exceptRecipeId).Scan(&exists)
 
 	if err != nil {
 		return false, err
@@ -570,3 +1037,77 @@ func checkSlugExists(ctx context.Context, slug string, profileId string) (bool,
 
 	return exists, nil
 }
+
+// recordSlugChange moves the recipe's current slug into the slug history
+// when it is being changed. It also rejects slugs that another of the
+// profile's recipes used before, so old links never point at the wrong recipe.
+func recordSlugChange(ctx context.Context, tx *sqldb.Tx, recipe *Recipe) error {
+	var historyRecipeId string
+	err := tx.QueryRow(ctx, `
+		SELECT recipe_id
+		FROM recipe_slug_history
+		WHERE profile_id = $1 AND LOWER(slug) = LOWER($2)
+	`, recipe.ProfileId, recipe.Slug).Scan(&historyRecipeId)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && historyRecipeId != recipe.Id {
+		return fmt.Errorf("slug is not available")
+	}
+
+	var oldSlug string
+	err = tx.QueryRow(ctx, `SELECT slug FROM recipe WHERE id = $1`, recipe.Id).Scan(&oldSlug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if strings.EqualFold(oldSlug, recipe.Slug) {
+		return nil
+	}
+
+	// Switching back to a previous slug makes it current again.
+	_, err = tx.Exec(ctx, `
+		DELETE FROM recipe_slug_history
+		WHERE recipe_id = $1 AND LOWER(slug) = LOWER($2)
+	`, recipe.Id, recipe.Slug)
+	if err != nil {
+		return fmt.Errorf("error updating slug history: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO recipe_slug_history (recipe_id, profile_id, slug)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, recipe.Id, recipe.ProfileId, oldSlug)
+	if err != nil {
+		return fmt.Errorf("error updating slug history: %w", err)
+	}
+
+	return nil
+}
+
+// resolveOldSlug returns the current slug of the profile's recipe that used
+// to have the given slug, or an empty string if none did.
+func resolveOldSlug(ctx context.Context, username string, slug string) (string, error) {
+	var currentSlug string
+	err := db.QueryRow(ctx, `
+		SELECT r.slug
+		FROM recipe_slug_history h
+		INNER JOIN recipe r ON h.recipe_id = r.id
+		INNER JOIN profile p ON h.profile_id = p.id
+		WHERE LOWER(p.username) = LOWER($1) AND LOWER(h.slug) = LOWER($2)
+	`, username, slug).Scan(&currentSlug)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return currentSlug, nil
+}
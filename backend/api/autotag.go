@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"encore.dev/beta/auth"
+	"encore.dev/cron"
+	"encore.dev/rlog"
+
+	"encore.app/backend/api/extraction"
+)
+
+const (
+	// autoTagBatchSize is how many untagged recipes each run of the
+	// auto-tagging job looks at.
+	autoTagBatchSize = 25
+	// maxAutoTagRecipeLength limits how much of a recipe is sent for tagging.
+	maxAutoTagRecipeLength = 4000
+	// maxAutoTagAttempts is how many times tagging a recipe may fail before
+	// the job stops trying it.
+	maxAutoTagAttempts = 3
+)
+
+// Suggest tags for untagged recipes every hour.
+var _ = cron.NewJob("suggest-recipe-tags", cron.JobConfig{
+	Title:    "Suggest tags for untagged recipes",
+	Every:    1 * cron.Hour,
+	Endpoint: SuggestRecipeTags,
+})
+
+const autoTagPrompt = `Assign tags to the recipe below. Respond with the provided schema.`
+
+type RecipeTagSuggestions struct {
+	Recipe *RecipeCard `json:"recipe"`
+	Tags   []string    `json:"tags"`
+}
+
+type TagSuggestionListResponse struct {
+	Suggestions []*RecipeTagSuggestions `json:"suggestions"`
+}
+
+type ReviewTagSuggestionsRequest struct {
+	// Accept lists suggested tags to add to the recipe.
+	Accept []string `json:"accept"`
+	// Reject lists suggested tags that will not be suggested again.
+	Reject []string `json:"reject"`
+}
+
+type autoTagResponse struct {
+	Tags []string `json:"tags"`
+}
+
+// GetTagSuggestions returns the pending tag suggestions for the caller's recipes.
+//
+//encore:api auth method=GET path=/api/tag-suggestions tag:read
+func GetTagSuggestions(ctx context.Context) (*TagSuggestionListResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT `+recipeCardColumns+`, array_agg(s.tag ORDER BY s.tag)
+		FROM tag_suggestion s
+		INNER JOIN recipe r ON s.recipe_id = r.id
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE r.profile_id = $1 AND s.status = 'pending'
+		GROUP BY r.id, p.username
+		ORDER BY r.title
+	`, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*RecipeTagSuggestions{}
+	for rows.Next() {
+		rs := &RecipeTagSuggestions{Recipe: &RecipeCard{}}
+		dest := append(recipeCardDest(rs.Recipe), &rs.Tags)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, rs)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &TagSuggestionListResponse{Suggestions: suggestions}, nil
+}
+
+// ReviewTagSuggestions accepts or rejects tags suggested for one of the
+// caller's recipes. Accepted tags are added to the recipe.
+//
+//encore:api auth method=POST path=/api/tag-suggestions/:recipeId
+func ReviewTagSuggestions(ctx context.Context, recipeId string, req *ReviewTagSuggestionsRequest) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	recipeProfileId, err := getRecipeProfileId(ctx, recipeId)
+	if err != nil {
+		return err
+	}
+	if recipeProfileId != string(authResult) {
+		return fmt.Errorf("not authorized to edit this recipe")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only pending suggestions can be accepted, so accepting cannot be used
+	// to add arbitrary tags.
+	rows, err := tx.Query(ctx, `
+		UPDATE tag_suggestion
+		SET status = 'accepted', decided_at = NOW()
+		WHERE recipe_id = $1 AND status = 'pending' AND tag = ANY($2)
+		RETURNING tag
+	`, recipeId, req.Accept)
+	if err != nil {
+		return fmt.Errorf("error accepting suggestions: %w", err)
+	}
+	accepted := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			rows.Close()
+			return err
+		}
+		accepted = append(accepted, tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE tag_suggestion
+		SET status = 'rejected', decided_at = NOW()
+		WHERE recipe_id = $1 AND status = 'pending' AND tag = ANY($2)
+	`, recipeId, req.Reject)
+	if err != nil {
+		return fmt.Errorf("error rejecting suggestions: %w", err)
+	}
+
+	if len(accepted) > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE recipe
+			SET tags = tags || ARRAY(SELECT unnest($2::text[]) EXCEPT SELECT unnest(tags)), updated_at = NOW()
+			WHERE id = $1
+		`, recipeId, accepted)
+		if err != nil {
+			return fmt.Errorf("error adding tags to recipe: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SuggestRecipeTags runs the tagging model over a batch of untagged recipes
+// and stores its answers as suggestions for the owners to review.
+//
+//encore:api private
+func SuggestRecipeTags(ctx context.Context) error {
+	rows, err := db.Query(ctx, `
+		SELECT r.id, r.profile_id, r.title, r.ingredients, r.instructions
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE (r.tags IS NULL OR r.tags = '{}')
+			AND r.auto_tagged_at IS NULL
+			AND r.auto_tag_attempts < $2
+			AND p.banned_at IS NULL
+		ORDER BY r.auto_tag_attempts, r.created_at
+		LIMIT $1
+	`, autoTagBatchSize, maxAutoTagAttempts)
+	if err != nil {
+		return err
+	}
+
+	var recipes []*Recipe
+	for rows.Next() {
+		r := &Recipe{}
+		if err := rows.Scan(&r.Id, &r.ProfileId, &r.Title, &r.Ingredients, &r.Instructions); err != nil {
+			rows.Close()
+			return err
+		}
+		recipes = append(recipes, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	vocabularies := map[string][]*Tag{}
+	suggested := 0
+	for _, recipe := range recipes {
+		vocabulary, ok := vocabularies[recipe.ProfileId]
+		if !ok {
+			vocabulary, err = getTagVocabulary(ctx, recipe.ProfileId)
+			if err != nil {
+				return err
+			}
+			vocabularies[recipe.ProfileId] = vocabulary
+		}
+
+		tags, err := suggestTags(ctx, recipe, vocabulary)
+		if errors.Is(err, errAIUnavailable) || ctx.Err() != nil {
+			// Leave the rest of the batch for the next run.
+			rlog.Warn("stopping auto-tagging early", "err", err)
+			break
+		}
+		if err != nil {
+			rlog.Error("error suggesting tags", "recipe_id", recipe.Id, "err", err)
+			// Count the failure so a recipe that keeps failing does not
+			// hold up the rest of the library.
+			_, err = db.Exec(ctx, `UPDATE recipe SET auto_tag_attempts = auto_tag_attempts + 1 WHERE id = $1`, recipe.Id)
+			if err != nil {
+				return fmt.Errorf("error updating recipe: %w", err)
+			}
+			continue
+		}
+
+		_, err = db.Exec(ctx, `
+			INSERT INTO tag_suggestion (recipe_id, tag)
+			SELECT $1, unnest($2::text[])
+			ON CONFLICT (recipe_id, tag) DO NOTHING
+		`, recipe.Id, tags)
+		if err != nil {
+			return fmt.Errorf("error saving tag suggestions: %w", err)
+		}
+		_, err = db.Exec(ctx, `UPDATE recipe SET auto_tagged_at = NOW() WHERE id = $1`, recipe.Id)
+		if err != nil {
+			return fmt.Errorf("error updating recipe: %w", err)
+		}
+		suggested++
+	}
+
+	rlog.Info("auto-tagged recipes", "count", suggested, "batch", len(recipes))
+	return nil
+}
+
+// suggestTags asks the model which tags from the vocabulary fit the recipe.
+func suggestTags(ctx context.Context, recipe *Recipe, vocabulary []*Tag) ([]string, error) {
+	if len(vocabulary) == 0 {
+		return []string{}, nil
+	}
+
+	text := extraction.Truncate(fmt.Sprintf("Title: %s\n\nIngredients:\n%s\n\nInstructions:\n%s",
+		recipe.Title, recipe.Ingredients, recipe.Instructions), maxAutoTagRecipeLength)
+	names := tagNames(vocabulary)
+	reqBody := OpenAIRequest{
+		Model: "gpt-4o-mini",
+		Messages: []Message{
+			{
+				Role: "user",
+				Content: []Content{{
+					Type: "text",
+					Text: autoTagPrompt + tagGuideline(vocabulary) + "\n\n" + text,
+				}},
+			},
+		},
+		MaxTokens: 200,
+		ResponseFormat: ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: JSONSchema{
+				Name: "tag_response",
+				Schema: Schema{
+					Type: "object",
+					Properties: map[string]Property{
+						"tags": {
+							Type:  "array",
+							Items: &Property{Type: "string", Enum: names},
+						},
+					},
+					Required:             []string{"tags"},
+					AdditionalProperties: false,
+				},
+				Strict: true,
+			},
+		},
+	}
+
+	openAIResp, err := submitRequestToOpenAI(ctx, reqBody)
+	if err != nil {
+		recordAiUsage(ctx, recipe.ProfileId, aiUsageKindTagging, nil, err)
+		return nil, err
+	}
+	usage := openAIResp.Usage
+	usage.Model = openAIResp.Model
+
+	var resp autoTagResponse
+	err = json.Unmarshal([]byte(openAIResp.Choices[0].Message.Content), &resp)
+	recordAiUsage(ctx, recipe.ProfileId, aiUsageKindTagging, &usage, err)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tags: %w", err)
+	}
+
+	return extraction.FilterAllowedTags(resp.Tags, names), nil
+}
@@ -0,0 +1,481 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+
+	authservice "encore.app/backend/auth"
+)
+
+// Comment and rating rate limits, applied per profile across all recipes.
+const (
+	maxCommentsPerMinute = 5
+	maxCommentsPerDay    = 100
+	maxCommentLength     = 2000
+	maxRatingsPerMinute  = 10
+	maxRatingsPerDay     = 200
+)
+
+type RateRecipeRequest struct {
+	Rating int16 `json:"rating"`
+}
+
+type RatingSummary struct {
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int     `json:"rating_count"`
+	MyRating      int16   `json:"my_rating"`
+}
+
+type Comment struct {
+	Id        string     `json:"id"`
+	ParentId  string     `json:"parent_id"`
+	Username  string     `json:"username"`
+	Body      string     `json:"body"`
+	Hidden    bool       `json:"hidden"` // only visible to the recipe owner
+	CreatedAt time.Time  `json:"created_at"`
+	Replies   []*Comment `json:"replies"`
+}
+
+type CommentsResponse struct {
+	Comments []*Comment `json:"comments"`
+}
+
+type AddCommentRequest struct {
+	Body     string `json:"body"`
+	ParentId string `json:"parent_id"`
+}
+
+type SetCommentHiddenRequest struct {
+	Hidden bool `json:"hidden"`
+}
+
+//encore:api auth method=POST path=/api/recipe-ratings/:recipeId
+func RateRecipe(ctx context.Context, recipeId string, req *RateRecipeRequest) (*RatingSummary, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if req.Rating < 1 || req.Rating > 5 {
+		return nil, fmt.Errorf("rating must be between 1 and 5")
+	}
+
+	if err := checkNotBanned(ctx, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	recipeProfileId, err := getVisibleRecipeProfileId(ctx, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	if recipeProfileId == string(authResult) {
+		return nil, fmt.Errorf("cannot rate your own recipe")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkRatingRateLimit(ctx, tx, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO recipe_rating (recipe_id, profile_id, rating)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (recipe_id, profile_id) DO UPDATE SET rating=$3, updated_at=NOW()
+	`, recipeId, string(authResult), req.Rating)
+	if err != nil {
+		return nil, fmt.Errorf("error saving rating: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getRatingSummary(ctx, recipeId, string(authResult))
+}
+
+//encore:api auth method=DELETE path=/api/recipe-ratings/:recipeId
+func DeleteRating(ctx context.Context, recipeId string) (*RatingSummary, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	_, err := db.Exec(ctx, `
+		DELETE FROM recipe_rating
+		WHERE recipe_id = $1 AND profile_id = $2
+	`, recipeId, string(authResult))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting rating: %w", err)
+	}
+
+	return getRatingSummary(ctx, recipeId, string(authResult))
+}
+
+//encore:api public method=GET path=/api/top-recipes tag:read
+func GetTopRatedRecipes(ctx context.Context) (*RecipeListResponse, error) {
+	recipeCards, err := queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE `+recipeListableCondition+` AND EXISTS (SELECT 1 FROM recipe_rating WHERE recipe_id = r.id)
+		ORDER BY 6 DESC, 7 DESC
+		LIMIT 25
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecipeListResponse{Recipes: recipeCards}, nil
+}
+
+// GetRecipeComments returns the comment threads on a recipe. Hidden comments
+// are only included for the recipe owner, so they can moderate them.
+//
+//encore:api public method=GET path=/api/recipe-comments/:recipeId tag:read
+func GetRecipeComments(ctx context.Context, recipeId string) (*CommentsResponse, error) {
+	recipeProfileId, err := getVisibleRecipeProfileId(ctx, recipeId)
+	if err != nil {
+		return nil, err
+	}
+
+	authResult, _ := auth.UserID()
+	isOwner := string(authResult) == recipeProfileId
+
+	rows, err := db.Query(ctx, `
+		SELECT c.id, COALESCE(c.parent_id, ''), p.username, c.body, c.hidden, c.created_at
+		FROM recipe_comment c
+		INNER JOIN profile p ON c.profile_id = p.id
+		WHERE c.recipe_id = $1 AND (NOT c.hidden OR $2)
+		ORDER BY c.created_at
+	`, recipeId, isOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		c := &Comment{}
+		if err := rows.Scan(&c.Id, &c.ParentId, &c.Username, &c.Body, &c.Hidden, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &CommentsResponse{Comments: threadComments(comments)}, nil
+}
+
+//encore:api auth method=POST path=/api/recipe-comments/:recipeId
+func AddComment(ctx context.Context, recipeId string, req *AddCommentRequest) (*Comment, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return nil, fmt.Errorf("comment cannot be empty")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return nil, fmt.Errorf("comment cannot be longer than %d characters", maxCommentLength)
+	}
+
+	if _, err := getVisibleRecipeProfileId(ctx, recipeId); err != nil {
+		return nil, err
+	}
+
+	if req.ParentId != "" {
+		var parentRecipeId string
+		err := db.QueryRow(ctx, `SELECT recipe_id FROM recipe_comment WHERE id = $1`, req.ParentId).Scan(&parentRecipeId)
+		if err != nil || parentRecipeId != recipeId {
+			return nil, fmt.Errorf("parent comment not found")
+		}
+	}
+
+	if err := checkNotBanned(ctx, string(authResult)); err != nil {
+		return nil, err
+	}
+	if err := checkEmailVerified(); err != nil {
+		return nil, err
+	}
+
+	commentId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating comment ID: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkCommentRateLimit(ctx, tx, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	comment := &Comment{Id: commentId.String(), ParentId: req.ParentId, Body: body}
+	err = tx.QueryRow(ctx, `
+		WITH inserted AS (
+			INSERT INTO recipe_comment (id, recipe_id, profile_id, parent_id, body)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+			RETURNING profile_id, created_at
+		)
+		SELECT p.username, i.created_at
+		FROM inserted i
+		INNER JOIN profile p ON i.profile_id = p.id
+	`, comment.Id, recipeId, string(authResult), req.ParentId, body).Scan(&comment.Username, &comment.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving comment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+// SetCommentHidden lets the recipe owner hide or unhide a comment.
+//
+//encore:api auth method=POST path=/api/recipe-comments/:recipeId/:commentId/hidden
+func SetCommentHidden(ctx context.Context, recipeId string, commentId string, req *SetCommentHiddenRequest) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	recipeProfileId, err := getRecipeProfileId(ctx, recipeId)
+	if err != nil {
+		return err
+	}
+	if recipeProfileId != string(authResult) {
+		return fmt.Errorf("not authorized to moderate comments on this recipe")
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE recipe_comment
+		SET hidden = $3
+		WHERE id = $1 AND recipe_id = $2
+	`, commentId, recipeId, req.Hidden)
+	if err != nil {
+		return fmt.Errorf("error updating comment: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("comment not found")
+	}
+
+	return nil
+}
+
+// DeleteComment removes a comment and its replies. Both the comment author
+// and the recipe owner may delete it.
+//
+//encore:api auth method=DELETE path=/api/recipe-comments/:recipeId/:commentId
+func DeleteComment(ctx context.Context, recipeId string, commentId string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	recipeProfileId, err := getRecipeProfileId(ctx, recipeId)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM recipe_comment
+		WHERE id = $1 AND recipe_id = $2 AND (profile_id = $3 OR $4)
+	`, commentId, recipeId, string(authResult), recipeProfileId == string(authResult))
+	if err != nil {
+		return fmt.Errorf("error deleting comment: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("comment not found")
+	}
+
+	return nil
+}
+
+func getRatingSummary(ctx context.Context, recipeId string, profileId string) (*RatingSummary, error) {
+	summary := &RatingSummary{}
+	err := db.QueryRow(ctx, `
+		SELECT
+			COALESCE(AVG(rating)::float8, 0),
+			COUNT(*),
+			COALESCE(MAX(rating) FILTER (WHERE profile_id = $2), 0)
+		FROM recipe_rating
+		WHERE recipe_id = $1
+	`, recipeId, profileId).Scan(&summary.AverageRating, &summary.RatingCount, &summary.MyRating)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+func getRecipeProfileId(ctx context.Context, recipeId string) (string, error) {
+	var profileId string
+	err := db.QueryRow(ctx, `SELECT profile_id FROM recipe WHERE id = $1`, recipeId).Scan(&profileId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("recipe not found")
+		}
+		return "", err
+	}
+
+	return profileId, nil
+}
+
+// getVisibleRecipeProfileId is getRecipeProfileId for recipes the caller may
+// see. As in GetRecipe, hidden recipes and recipes of hidden or banned
+// profiles are only found by their owner and admins.
+func getVisibleRecipeProfileId(ctx context.Context, recipeId string) (string, error) {
+	var profileId string
+	var listable bool
+	err := db.QueryRow(ctx, `
+		SELECT r.profile_id, `+recipeListableCondition+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE r.id = $1
+	`, recipeId).Scan(&profileId, &listable)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("recipe not found")
+		}
+		return "", err
+	}
+
+	if !listable {
+		authResult, _ := auth.UserID()
+		if string(authResult) != profileId && !isAdmin() {
+			return "", fmt.Errorf("recipe not found")
+		}
+	}
+
+	return profileId, nil
+}
+
+// checkEmailVerified returns an error if the caller signed up with an email
+// and password and has not verified the address yet, so throwaway accounts
+// cannot post comments. Other providers, such as Google, verify emails
+// themselves.
+func checkEmailVerified() error {
+	userData, _ := auth.Data().(*authservice.UserData)
+	if userData != nil && userData.SignInProvider == "password" && !userData.EmailVerified {
+		return fmt.Errorf("please verify your email address before commenting")
+	}
+	return nil
+}
+
+// checkCommentRateLimit locks the profile's comments for the rest of the
+// transaction, so concurrent requests cannot all pass the check before any
+// of them inserts.
+func checkCommentRateLimit(ctx context.Context, tx *sqldb.Tx, profileId string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('comment:' || $1))`, profileId)
+	if err != nil {
+		return err
+	}
+
+	var lastMinute, lastDay int
+	err = tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 minute'),
+			COUNT(*)
+		FROM recipe_comment
+		WHERE profile_id = $1 AND created_at > NOW() - INTERVAL '1 day'
+	`, profileId).Scan(&lastMinute, &lastDay)
+	if err != nil {
+		return err
+	}
+
+	if lastMinute >= maxCommentsPerMinute || lastDay >= maxCommentsPerDay {
+		return fmt.Errorf("too many comments, please try again later")
+	}
+
+	return nil
+}
+
+// checkRatingRateLimit works like checkCommentRateLimit, counting the
+// ratings set recently. Each call is logged as an attempt, because ratings
+// can be deleted and set again.
+func checkRatingRateLimit(ctx context.Context, tx *sqldb.Tx, profileId string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('rating:' || $1))`, profileId)
+	if err != nil {
+		return err
+	}
+
+	var lastMinute, lastDay int
+	err = tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE attempted_at > NOW() - INTERVAL '1 minute'),
+			COUNT(*)
+		FROM recipe_rating_attempt
+		WHERE profile_id = $1 AND attempted_at > NOW() - INTERVAL '1 day'
+	`, profileId).Scan(&lastMinute, &lastDay)
+	if err != nil {
+		return err
+	}
+
+	if lastMinute >= maxRatingsPerMinute || lastDay >= maxRatingsPerDay {
+		return fmt.Errorf("too many ratings, please try again later")
+	}
+
+	// Attempts older than the window are no longer needed.
+	_, err = tx.Exec(ctx, `
+		DELETE FROM recipe_rating_attempt
+		WHERE profile_id = $1 AND attempted_at <= NOW() - INTERVAL '1 day'
+	`, profileId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO recipe_rating_attempt (profile_id)
+		VALUES ($1)
+	`, profileId)
+	if err != nil {
+		return fmt.Errorf("error recording rating: %w", err)
+	}
+
+	return nil
+}
+
+// threadComments nests replies under their parent. Comments must be ordered
+// by creation time so parents are seen before their replies. Replies whose
+// parent is hidden from the caller are dropped along with it.
+func threadComments(comments []*Comment) []*Comment {
+	byId := make(map[string]*Comment, len(comments))
+	var roots []*Comment
+	for _, c := range comments {
+		byId[c.Id] = c
+		if c.ParentId == "" {
+			roots = append(roots, c)
+			continue
+		}
+		if parent, ok := byId[c.ParentId]; ok {
+			parent.Replies = append(parent.Replies, c)
+		}
+	}
+
+	return roots
+}
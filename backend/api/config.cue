@@ -0,0 +1,7 @@
+// The OpenAI compatible API used for recipe imports. Point it at a local
+// stand-in to run without calling OpenAI.
+OpenAIBaseURL: "https://api.openai.com/v1"
+
+// Give the model one more chance to fix an extracted recipe that is missing
+// its title, ingredients or instructions.
+RepromptInvalidRecipes: true
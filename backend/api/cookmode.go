@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"encore.app/backend/api/extraction"
+	"encore.app/backend/api/recipetext"
+)
+
+// Cook mode card kinds, in the order they appear.
+const (
+	cookModeCardOverview    = "overview"
+	cookModeCardIngredients = "ingredients"
+	cookModeCardStep        = "step"
+	cookModeCardNotes       = "notes"
+)
+
+// CookModeCard is one screen of cook mode. Every card can be read on its
+// own: step cards repeat the quantities of the ingredients they use, and
+// abbreviations are spelled out.
+type CookModeCard struct {
+	Kind    string `json:"kind"`
+	Heading string `json:"heading"` // e.g. "Step 3 of 8"
+	Section string `json:"section"`
+	Text    string `json:"text"`
+	// Items are the lines listed on the card: all ingredients on the
+	// ingredients card, or the ingredients a step uses.
+	Items    []string     `json:"items"`
+	Timers   []*StepTimer `json:"timers"`
+	TempDegF int16        `json:"temp_deg_f"`
+}
+
+type CookModeResponse struct {
+	Title string          `json:"title"`
+	Cards []*CookModeCard `json:"cards"`
+	// Ssml is the whole recipe as SSML for text-to-speech, with a pause
+	// between cards.
+	Ssml string `json:"ssml"`
+	// Redirect is set when the recipe was found under an old URL.
+	Redirect *Redirect `json:"redirect,omitempty"`
+}
+
+// GetCookMode returns the recipe as a sequence of large, self-contained
+// cards for cooking from a tablet or phone, along with an SSML rendering
+// for text-to-speech.
+//
+//encore:api public method=GET path=/api/recipes/:username/:slug/cook-mode tag:read
+func GetCookMode(ctx context.Context, username string, slug string) (*CookModeResponse, error) {
+	recipe, err := GetRecipe(ctx, username, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := getRecipeSteps(ctx, recipe)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := buildCookModeCards(recipe, steps)
+	return &CookModeResponse{
+		Title:    recipe.Title,
+		Cards:    cards,
+		Ssml:     cookModeSsml(cards),
+		Redirect: recipe.Redirect,
+	}, nil
+}
+
+func buildCookModeCards(recipe *Recipe, steps []*RecipeStep) []*CookModeCard {
+	cards := []*CookModeCard{{
+		Kind:    cookModeCardOverview,
+		Heading: recipe.Title,
+		Text:    cookModeOverview(recipe),
+		Items:   []string{},
+		Timers:  []*StepTimer{},
+	}}
+
+	ingredients := recipetext.IngredientItems(recipe.Ingredients)
+	if len(ingredients) > 0 {
+		items := make([]string, 0, len(ingredients))
+		for _, ingredient := range ingredients {
+			items = append(items, cookModeText(ingredient))
+		}
+		cards = append(cards, &CookModeCard{
+			Kind:    cookModeCardIngredients,
+			Heading: "Ingredients",
+			Items:   items,
+			Timers:  []*StepTimer{},
+		})
+	}
+
+	// Instructions that could not be split into steps, e.g. headings alone,
+	// are still read out, one card per paragraph.
+	if len(steps) == 0 {
+		steps = instructionParagraphs(recipe.Instructions)
+	}
+
+	for i, step := range steps {
+		items := make([]string, 0, len(step.Ingredients))
+		for _, ingredient := range step.Ingredients {
+			items = append(items, cookModeText(ingredient))
+		}
+		cards = append(cards, &CookModeCard{
+			Kind:     cookModeCardStep,
+			Heading:  fmt.Sprintf("Step %d of %d", i+1, len(steps)),
+			Section:  step.Section,
+			Text:     cookModeText(step.Text),
+			Items:    items,
+			Timers:   step.Timers,
+			TempDegF: step.TempDegF,
+		})
+	}
+
+	if notes := strings.TrimSpace(recipe.Notes); notes != "" {
+		cards = append(cards, &CookModeCard{
+			Kind:    cookModeCardNotes,
+			Heading: "Notes",
+			Text:    cookModeText(notes),
+			Items:   []string{},
+			Timers:  []*StepTimer{},
+		})
+	}
+
+	return cards
+}
+
+// instructionParagraphs turns each paragraph of the instructions into a
+// step without timers or ingredients.
+func instructionParagraphs(instructions string) []*RecipeStep {
+	steps := []*RecipeStep{}
+	for _, paragraph := range strings.Split(cleanMarkdown(instructions), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			steps = append(steps, &RecipeStep{
+				Position:    len(steps) + 1,
+				Text:        strings.TrimLeft(paragraph, "# "),
+				Timers:      []*StepTimer{},
+				Ingredients: []string{},
+			})
+		}
+	}
+	return steps
+}
+
+// cookModeOverview summarises what the recipe makes and how long it takes.
+func cookModeOverview(recipe *Recipe) string {
+	var parts []string
+	if recipe.Servings != nil && *recipe.Servings > 0 {
+		parts = append(parts, fmt.Sprintf("Serves %d.", *recipe.Servings))
+	}
+	if recipe.Yield != nil && *recipe.Yield != "" {
+		parts = append(parts, fmt.Sprintf("Makes %s.", cookModeText(*recipe.Yield)))
+	}
+
+	times := []struct {
+		label   string
+		minutes *int16
+	}{
+		{"Prep", recipe.PrepTimeMinutes},
+		{"Cook", &recipe.CookTimeMinutes},
+		{"Rest", recipe.RestTimeMinutes},
+		{"Total", recipe.TotalTimeMinutes},
+	}
+	for _, t := range times {
+		if t.minutes != nil && *t.minutes > 0 {
+			parts = append(parts, fmt.Sprintf("%s time %s.", t.label, spokenMinutes(int(*t.minutes))))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func spokenMinutes(minutes int) string {
+	hours, minutes := minutes/60, minutes%60
+	var parts []string
+	if hours > 0 {
+		parts = append(parts, pluralize(hours, "hour", "hours"))
+	}
+	if minutes > 0 {
+		parts = append(parts, pluralize(minutes, "minute", "minutes"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func pluralize(n int, singular string, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// cookModeText turns a line of Markdown into plain text with unicode
+// fractions written out and abbreviations expanded.
+func cookModeText(text string) string {
+	text = renderInlineText(cleanMarkdown(text))
+	text = strings.Join(strings.Fields(text), " ")
+	return recipetext.ExpandAbbreviations(extraction.ReplaceUnicodeFractions(text))
+}
+
+// cookModeSsml renders the cards as SSML, reading temperatures and common
+// fractions the way a person would say them.
+func cookModeSsml(cards []*CookModeCard) string {
+	var b strings.Builder
+	b.WriteString("<speak>")
+	for i, card := range cards {
+		if i > 0 {
+			b.WriteString(`<break time="1500ms"/>`)
+		}
+		b.WriteString("<p>")
+		writeSsmlSentence(&b, card.Heading)
+		if card.Section != "" {
+			writeSsmlSentence(&b, card.Section)
+		}
+		if card.Kind == cookModeCardStep && len(card.Items) > 0 {
+			writeSsmlSentence(&b, "You will need "+strings.Join(card.Items, "; "))
+		}
+		if card.Text != "" {
+			writeSsmlSentence(&b, card.Text)
+		}
+		if card.Kind == cookModeCardIngredients {
+			for _, item := range card.Items {
+				writeSsmlSentence(&b, item)
+			}
+		}
+		b.WriteString("</p>")
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+func writeSsmlSentence(b *strings.Builder, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	b.WriteString("<s>")
+	xml.EscapeText(b, []byte(recipetext.SpokenText(text)))
+	b.WriteString("</s>")
+}
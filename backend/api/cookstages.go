@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"encore.dev/storage/sqldb"
+
+	"encore.app/backend/api/extraction"
+)
+
+const (
+	maxCookStages          = extraction.MaxCookStages
+	maxCookStageNoteLength = extraction.MaxCookStageNoteLength
+)
+
+// cookMethods are the allowed CookStage methods.
+var cookMethods = extraction.CookMethods
+
+// CookStage is one step of cooking at a single temperature, e.g. 20 minutes
+// in the oven at 425°F.
+type CookStage struct {
+	Method          string `json:"method"`
+	TempDegF        int16  `json:"temp_deg_f"` // 0 when there is no set temperature
+	DurationMinutes int16  `json:"duration_minutes"`
+	Note            string `json:"note"`
+}
+
+func validateCookStages(stages []*CookStage) error {
+	if len(stages) > maxCookStages {
+		return fmt.Errorf("a recipe can have at most %d cook stages", maxCookStages)
+	}
+	for i, stage := range stages {
+		if stage == nil || !extraction.IsValidCookMethod(stage.Method) {
+			return fmt.Errorf("cook stage %d has an invalid method", i+1)
+		}
+		if stage.TempDegF < 0 || stage.TempDegF > maxCookTempDegF {
+			return fmt.Errorf("cook stage %d temperature must be between 0 and %d°F", i+1, maxCookTempDegF)
+		}
+		if stage.DurationMinutes < 0 || stage.DurationMinutes > maxCookTimeMinutes {
+			return fmt.Errorf("cook stage %d duration must be between 0 and %d minutes", i+1, maxCookTimeMinutes)
+		}
+		if utf8.RuneCountInString(stage.Note) > maxCookStageNoteLength {
+			return fmt.Errorf("cook stage %d note cannot be longer than %d characters", i+1, maxCookStageNoteLength)
+		}
+	}
+	return nil
+}
+
+// deriveLegacyCookFields sets CookTempDegF to the first stage temperature and
+// CookTimeMinutes to the total duration, for clients that predate stages.
+func deriveLegacyCookFields(recipe *Recipe) {
+	if len(recipe.CookStages) == 0 {
+		return
+	}
+
+	recipe.CookTempDegF = 0
+	total := 0
+	for _, stage := range recipe.CookStages {
+		if recipe.CookTempDegF == 0 {
+			recipe.CookTempDegF = stage.TempDegF
+		}
+		total += int(stage.DurationMinutes)
+	}
+	recipe.CookTimeMinutes = int16(min(total, maxCookTimeMinutes))
+}
+
+// resolveCookStages decides the stages to store for a recipe being saved.
+// Clients that predate stages omit them; their recipe keeps its stored
+// stages unless the legacy fields were changed, in which case a single stage
+// is built from those fields.
+func resolveCookStages(ctx context.Context, recipe *Recipe) error {
+	if recipe.CookStages != nil {
+		if err := validateCookStages(recipe.CookStages); err != nil {
+			return err
+		}
+		deriveLegacyCookFields(recipe)
+		return nil
+	}
+
+	existing, err := loadCookStages(ctx, recipe.Id)
+	if err != nil {
+		return err
+	}
+	current := &Recipe{CookStages: existing}
+	deriveLegacyCookFields(current)
+	if len(existing) > 0 && current.CookTempDegF == recipe.CookTempDegF && current.CookTimeMinutes == recipe.CookTimeMinutes {
+		recipe.CookStages = existing
+		return nil
+	}
+
+	recipe.CookStages = []*CookStage{}
+	if recipe.CookTempDegF > 0 || recipe.CookTimeMinutes > 0 {
+		method := "other"
+		if recipe.CookTempDegF > 0 {
+			method = "oven"
+		}
+		recipe.CookStages = append(recipe.CookStages, &CookStage{
+			Method:          method,
+			TempDegF:        recipe.CookTempDegF,
+			DurationMinutes: recipe.CookTimeMinutes,
+		})
+	}
+	return validateCookStages(recipe.CookStages)
+}
+
+func loadCookStages(ctx context.Context, recipeId string) ([]*CookStage, error) {
+	rows, err := db.Query(ctx, `
+		SELECT method, temp_deg_f, duration_minutes, note
+		FROM recipe_cook_stage
+		WHERE recipe_id = $1
+		ORDER BY position
+	`, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stages := []*CookStage{}
+	for rows.Next() {
+		s := &CookStage{}
+		if err := rows.Scan(&s.Method, &s.TempDegF, &s.DurationMinutes, &s.Note); err != nil {
+			return nil, err
+		}
+		stages = append(stages, s)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return stages, nil
+}
+
+// saveCookStages replaces the recipe's stored stages.
+func saveCookStages(ctx context.Context, tx *sqldb.Tx, recipeId string, stages []*CookStage) error {
+	_, err := tx.Exec(ctx, `DELETE FROM recipe_cook_stage WHERE recipe_id = $1`, recipeId)
+	if err != nil {
+		return fmt.Errorf("error saving cook stages: %w", err)
+	}
+
+	for i, stage := range stages {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO recipe_cook_stage (recipe_id, position, method, temp_deg_f, duration_minutes, note)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, recipeId, i, stage.Method, stage.TempDegF, stage.DurationMinutes, stage.Note)
+		if err != nil {
+			return fmt.Errorf("error saving cook stages: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/cron"
+	"encore.dev/rlog"
+	"encore.dev/types/uuid"
+)
+
+const (
+	// draftLifetimeDays is how long a draft is kept after it was last edited.
+	draftLifetimeDays   = 7
+	maxDraftsPerProfile = 50
+)
+
+// Delete expired drafts every hour.
+var _ = cron.NewJob("delete-expired-drafts", cron.JobConfig{
+	Title:    "Delete expired recipe drafts",
+	Every:    1 * cron.Hour,
+	Endpoint: DeleteExpiredDrafts,
+})
+
+// RecipeDraft is a recipe extracted by the AI import that has not been
+// saved yet, so the user can review and correct it first.
+type RecipeDraft struct {
+	Id        string    `json:"id"`
+	Source    string    `json:"source"` // "image" or "text"
+	Recipe    *Recipe   `json:"recipe"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type DraftListResponse struct {
+	Drafts []*RecipeDraft `json:"drafts"`
+}
+
+//encore:api auth method=GET path=/api/drafts tag:read
+func GetMyDrafts(ctx context.Context) (*DraftListResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, source, recipe, created_at, updated_at, expires_at
+		FROM recipe_draft
+		WHERE profile_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []*RecipeDraft{}
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &DraftListResponse{Drafts: drafts}, nil
+}
+
+//encore:api auth method=GET path=/api/drafts/:id tag:read
+func GetDraft(ctx context.Context, id string) (*RecipeDraft, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	return getDraft(ctx, id, string(authResult))
+}
+
+// UpdateDraft replaces the draft's recipe with the user's corrections and
+// extends its expiry.
+//
+//encore:api auth method=POST path=/api/drafts/:id
+func UpdateDraft(ctx context.Context, id string, recipe *Recipe) (*RecipeDraft, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	recipeJSON, err := marshalDraftRecipe(recipe)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE recipe_draft
+		SET recipe = $3, updated_at = NOW(), expires_at = NOW() + make_interval(days => $4)
+		WHERE id = $1 AND profile_id = $2 AND expires_at > NOW()
+	`, id, string(authResult), recipeJSON, draftLifetimeDays)
+	if err != nil {
+		return nil, fmt.Errorf("error updating draft: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, fmt.Errorf("draft not found")
+	}
+
+	return getDraft(ctx, id, string(authResult))
+}
+
+// CommitDraft saves the draft as a new recipe and deletes the draft.
+//
+//encore:api auth method=POST path=/api/drafts/:id/commit
+func CommitDraft(ctx context.Context, id string) (*GenerateRecipeResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Deleting the draft claims it, so concurrent commits of the same draft
+	// wait for this transaction and then find nothing to commit.
+	var recipeJSON []byte
+	err = tx.QueryRow(ctx, `
+		DELETE FROM recipe_draft
+		WHERE id = $1 AND profile_id = $2 AND expires_at > NOW()
+		RETURNING recipe
+	`, id, string(authResult)).Scan(&recipeJSON)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("draft not found")
+		}
+		return nil, err
+	}
+
+	var recipe *Recipe
+	if err := json.Unmarshal(recipeJSON, &recipe); err != nil {
+		return nil, fmt.Errorf("error reading draft: %w", err)
+	}
+
+	if err := createGeneratedRecipe(ctx, tx, string(authResult), recipe); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	response, err := getAddRecipeResponse(ctx, recipe.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error generating recipe response: %w", err)
+	}
+
+	return response, nil
+}
+
+//encore:api auth method=DELETE path=/api/drafts/:id
+func DeleteDraft(ctx context.Context, id string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM recipe_draft
+		WHERE id = $1 AND profile_id = $2
+	`, id, string(authResult))
+	if err != nil {
+		return fmt.Errorf("error deleting draft: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("draft not found")
+	}
+
+	return nil
+}
+
+//encore:api private
+func DeleteExpiredDrafts(ctx context.Context) error {
+	result, err := db.Exec(ctx, `DELETE FROM recipe_draft WHERE expires_at <= NOW()`)
+	if err != nil {
+		return fmt.Errorf("error deleting expired drafts: %w", err)
+	}
+
+	rlog.Info("deleted expired drafts", "count", result.RowsAffected())
+	return nil
+}
+
+// saveRecipeDraft stores an extracted recipe as a draft instead of saving it.
+func saveRecipeDraft(ctx context.Context, profileId string, source string, recipe *Recipe) (*GenerateRecipeResponse, error) {
+	var draftCount int
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM recipe_draft
+		WHERE profile_id = $1 AND expires_at > NOW()
+	`, profileId).Scan(&draftCount)
+	if err != nil {
+		return nil, err
+	}
+	if draftCount >= maxDraftsPerProfile {
+		return nil, fmt.Errorf("you have too many drafts, please save or delete some first")
+	}
+
+	recipeJSON, err := marshalDraftRecipe(recipe)
+	if err != nil {
+		return nil, err
+	}
+
+	draftId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating draft ID: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO recipe_draft (id, profile_id, source, recipe, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(days => $5))
+	`, draftId.String(), profileId, source, recipeJSON, draftLifetimeDays)
+	if err != nil {
+		return nil, fmt.Errorf("error saving draft: %w", err)
+	}
+
+	draft, err := getDraft(ctx, draftId.String(), profileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerateRecipeResponse{Draft: draft}, nil
+}
+
+func getDraft(ctx context.Context, id string, profileId string) (*RecipeDraft, error) {
+	row := db.QueryRow(ctx, `
+		SELECT id, source, recipe, created_at, updated_at, expires_at
+		FROM recipe_draft
+		WHERE id = $1 AND profile_id = $2 AND expires_at > NOW()
+	`, id, profileId)
+
+	draft, err := scanDraft(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("draft not found")
+		}
+		return nil, err
+	}
+
+	return draft, nil
+}
+
+func scanDraft(row interface{ Scan(...interface{}) error }) (*RecipeDraft, error) {
+	d := &RecipeDraft{}
+	var recipeJSON []byte
+	if err := row.Scan(&d.Id, &d.Source, &recipeJSON, &d.CreatedAt, &d.UpdatedAt, &d.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(recipeJSON, &d.Recipe); err != nil {
+		return nil, fmt.Errorf("error reading draft: %w", err)
+	}
+
+	return d, nil
+}
+
+// marshalDraftRecipe keeps only the recipe content; the ID, owner and slug
+// are assigned when the draft is committed.
+func marshalDraftRecipe(recipe *Recipe) ([]byte, error) {
+	if recipe == nil {
+		return nil, fmt.Errorf("recipe is required")
+	}
+	content := &Recipe{
+		Title:            recipe.Title,
+		Ingredients:      recipe.Ingredients,
+		Instructions:     recipe.Instructions,
+		Notes:            recipe.Notes,
+		CookTempDegF:     recipe.CookTempDegF,
+		CookTimeMinutes:  recipe.CookTimeMinutes,
+		Servings:         recipe.Servings,
+		Yield:            recipe.Yield,
+		PrepTimeMinutes:  recipe.PrepTimeMinutes,
+		RestTimeMinutes:  recipe.RestTimeMinutes,
+		TotalTimeMinutes: recipe.TotalTimeMinutes,
+		CookStages:       recipe.CookStages,
+		Tags:             recipe.Tags,
+		ImageUrl:         recipe.ImageUrl,
+		HouseholdId:      recipe.HouseholdId,
+	}
+	if content.Tags == nil {
+		content.Tags = []string{}
+	}
+
+	return json.Marshal(content)
+}
@@ -0,0 +1,269 @@
+// Package extraction parses and repairs recipes extracted by the AI import.
+// It has no Encore dependencies so it can be tested with plain go test.
+package extraction
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits for values extracted by the AI import; anything outside them is a
+// misread and is clamped.
+const (
+	MaxCookTimeMinutes = 48 * 60
+	MaxCookTempDegF    = 1000
+	MaxServings        = 200
+	// Rest times can span days, e.g. curing or a long cold ferment.
+	MaxRestTimeMinutes     = 14 * 24 * 60
+	MaxYieldLength         = 100
+	MaxCookStages          = 10
+	MaxCookStageNoteLength = 200
+)
+
+// CookMethods are the allowed cook stage methods.
+var CookMethods = []string{"oven", "stovetop", "grill", "air_fryer", "slow_cooker", "pressure_cooker", "microwave", "other"}
+
+// Recipe is a recipe as the model answers it, following the schema of the
+// extraction request.
+type Recipe struct {
+	Title            string       `json:"title"`
+	Ingredients      string       `json:"ingredients"`
+	Instructions     string       `json:"instructions"`
+	Notes            string       `json:"notes"`
+	CookStages       []*CookStage `json:"cook_stages"`
+	Servings         Int          `json:"servings"`
+	Yield            string       `json:"yield"`
+	PrepTimeMinutes  Int          `json:"prep_time_minutes"`
+	RestTimeMinutes  Int          `json:"rest_time_minutes"`
+	TotalTimeMinutes Int          `json:"total_time_minutes"`
+	Tags             []string     `json:"tags"`
+}
+
+type CookStage struct {
+	Method          string `json:"method"`
+	TempDegF        Int    `json:"temp_deg_f"`
+	DurationMinutes Int    `json:"duration_minutes"`
+	Note            string `json:"note"`
+}
+
+// Int is a number in the model's answer. It accepts any JSON number, rounding
+// fractions and saturating at the int32 range, so a misread such as 99999 or
+// 12.5 is clamped by Normalize rather than failing to decode.
+type Int int
+
+func (n *Int) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*n = 0
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	*n = Int(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(f))))
+	return nil
+}
+
+var unicodeFractions = map[rune]string{
+	'½': "1/2", '⅓': "1/3", '⅔': "2/3", '¼': "1/4", '¾': "3/4",
+	'⅕': "1/5", '⅖': "2/5", '⅗': "3/5", '⅘': "4/5", '⅙': "1/6",
+	'⅚': "5/6", '⅛': "1/8", '⅜': "3/8", '⅝': "5/8", '⅞': "7/8",
+}
+
+var (
+	// listItemPattern matches a bulleted or numbered list item, capturing its text.
+	listItemPattern = regexp.MustCompile(`^\s*(?:[*\-+•·▪]|\d+[.)]|(?i:step)\s*\d+[:.)]?)\s+(.*)$`)
+	// fractionAfterDigit matches a digit directly followed by a unicode fraction, as in "1½".
+	fractionAfterDigit = regexp.MustCompile(`(\d)([½⅓⅔¼¾⅕⅖⅗⅘⅙⅚⅛⅜⅝⅞])`)
+)
+
+// Parse decodes the model's answer.
+func Parse(content string) (*Recipe, error) {
+	var recipe Recipe
+	if err := json.Unmarshal([]byte(content), &recipe); err != nil {
+		return nil, fmt.Errorf("error parsing recipe: %v", err)
+	}
+	return &recipe, nil
+}
+
+// Normalize repairs formatting the model commonly gets wrong: list markers,
+// unicode fractions, tags outside the vocabulary and out of range times,
+// temperatures and servings. It returns the problems it could not repair.
+func Normalize(recipe *Recipe, allowedTags []string) []string {
+	recipe.Title = strings.TrimSpace(recipe.Title)
+	recipe.Ingredients = normalizeList(ReplaceUnicodeFractions(recipe.Ingredients), false)
+	recipe.Instructions = normalizeList(ReplaceUnicodeFractions(recipe.Instructions), true)
+	recipe.Notes = strings.TrimSpace(ReplaceUnicodeFractions(recipe.Notes))
+	recipe.Tags = FilterAllowedTags(recipe.Tags, allowedTags)
+	recipe.CookStages = normalizeCookStages(recipe.CookStages)
+	recipe.Servings = clamp(recipe.Servings, 0, MaxServings)
+	recipe.Yield = Truncate(strings.TrimSpace(recipe.Yield), MaxYieldLength)
+	recipe.PrepTimeMinutes = clamp(recipe.PrepTimeMinutes, 0, MaxCookTimeMinutes)
+	recipe.RestTimeMinutes = clamp(recipe.RestTimeMinutes, 0, MaxRestTimeMinutes)
+	recipe.TotalTimeMinutes = clamp(recipe.TotalTimeMinutes, 0, MaxRestTimeMinutes+2*MaxCookTimeMinutes)
+	// A total shorter than its parts is a misread, so derive it instead.
+	if parts := recipe.PrepTimeMinutes + recipe.RestTimeMinutes + cookTimeMinutes(recipe.CookStages); recipe.TotalTimeMinutes < parts {
+		recipe.TotalTimeMinutes = parts
+	}
+
+	var problems []string
+	if recipe.Title == "" {
+		problems = append(problems, "the title is empty")
+	}
+	if !hasListItem(recipe.Ingredients) {
+		problems = append(problems, "the ingredients are not a Markdown unordered list")
+	}
+	if !hasListItem(recipe.Instructions) {
+		problems = append(problems, "the instructions are not a Markdown ordered list")
+	}
+
+	return problems
+}
+
+// normalizeCookStages drops empty stages and clamps values, rather than
+// rejecting them.
+func normalizeCookStages(stages []*CookStage) []*CookStage {
+	normalized := []*CookStage{}
+	for _, stage := range stages {
+		if stage == nil || (stage.TempDegF <= 0 && stage.DurationMinutes <= 0) {
+			continue
+		}
+		if !IsValidCookMethod(stage.Method) {
+			stage.Method = "other"
+		}
+		stage.TempDegF = clamp(stage.TempDegF, 0, MaxCookTempDegF)
+		stage.DurationMinutes = clamp(stage.DurationMinutes, 0, MaxCookTimeMinutes)
+		stage.Note = Truncate(stage.Note, MaxCookStageNoteLength)
+		normalized = append(normalized, stage)
+		if len(normalized) == MaxCookStages {
+			break
+		}
+	}
+	return normalized
+}
+
+// cookTimeMinutes is the total duration of the stages, as the recipe's
+// cook time is derived when it is saved.
+func cookTimeMinutes(stages []*CookStage) Int {
+	var total Int
+	for _, stage := range stages {
+		total += stage.DurationMinutes
+	}
+	return min(total, MaxCookTimeMinutes)
+}
+
+func IsValidCookMethod(method string) bool {
+	for _, m := range CookMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceUnicodeFractions writes unicode fractions out with a slash, e.g.
+// "1½" becomes "1 1/2".
+func ReplaceUnicodeFractions(s string) string {
+	s = fractionAfterDigit.ReplaceAllString(s, "$1 $2")
+	s = strings.ReplaceAll(s, "⁄", "/")
+
+	var b strings.Builder
+	for _, r := range s {
+		if fraction, ok := unicodeFractions[r]; ok {
+			b.WriteString(fraction)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// normalizeList rewrites every list item with the expected marker: "* " for
+// unordered lists, or numbers for ordered lists. Numbering restarts after
+// any line that is not a list item, such as a sub-heading.
+func normalizeList(text string, ordered bool) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	number := 0
+	for i, line := range lines {
+		m := listItemPattern.FindStringSubmatch(line)
+		if m == nil {
+			if strings.TrimSpace(line) != "" {
+				number = 0
+			}
+			lines[i] = strings.TrimRight(line, " \t\r")
+			continue
+		}
+
+		if ordered {
+			number++
+			lines[i] = strconv.Itoa(number) + ". " + strings.TrimSpace(m[1])
+		} else {
+			lines[i] = "* " + strings.TrimSpace(m[1])
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ListItemText returns the text of a bulleted or numbered list item, and
+// false if the line is not one.
+func ListItemText(line string) (string, bool) {
+	m := listItemPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return strings.TrimSpace(m[1]), true
+}
+
+func hasListItem(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if listItemPattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterAllowedTags maps tags onto the vocabulary ignoring case, dropping
+// unknown tags and duplicates.
+func FilterAllowedTags(tags []string, allowedTags []string) []string {
+	filtered := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		for _, allowed := range allowedTags {
+			if strings.EqualFold(strings.TrimSpace(tag), allowed) && !seen[allowed] {
+				seen[allowed] = true
+				filtered = append(filtered, allowed)
+			}
+		}
+	}
+	return filtered
+}
+
+func clamp(v Int, lo Int, hi Int) Int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// Truncate shortens s to at most n characters, ending it with "…" if it
+// was cut. It cuts between characters, never inside one.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
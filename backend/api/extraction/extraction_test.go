@@ -0,0 +1,263 @@
+package extraction
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseClampsOutOfRangeNumbers(t *testing.T) {
+	recipe, err := Parse(`{
+		"title": "Brisket",
+		"servings": 40000,
+		"prep_time_minutes": 12.6,
+		"rest_time_minutes": -5,
+		"total_time_minutes": 1e12,
+		"cook_stages": [{"method": "oven", "temp_deg_f": 99999, "duration_minutes": 600, "note": ""}]
+	}`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if recipe.Servings != 40000 || recipe.PrepTimeMinutes != 13 || recipe.RestTimeMinutes != -5 || recipe.CookStages[0].TempDegF != 99999 {
+		t.Errorf("decoded %+v", recipe)
+	}
+
+	Normalize(recipe, nil)
+	if recipe.Servings != MaxServings {
+		t.Errorf("Servings = %d, want %d", recipe.Servings, MaxServings)
+	}
+	if recipe.RestTimeMinutes != 0 {
+		t.Errorf("RestTimeMinutes = %d, want 0", recipe.RestTimeMinutes)
+	}
+	if recipe.TotalTimeMinutes != MaxRestTimeMinutes+2*MaxCookTimeMinutes {
+		t.Errorf("TotalTimeMinutes = %d, want the maximum", recipe.TotalTimeMinutes)
+	}
+	if recipe.CookStages[0].TempDegF != MaxCookTempDegF {
+		t.Errorf("TempDegF = %d, want %d", recipe.CookStages[0].TempDegF, MaxCookTempDegF)
+	}
+}
+
+func TestParseRejectsInvalidJSON(t *testing.T) {
+	for _, content := range []string{``, `{"title": "x"`, `{"servings": "four"}`} {
+		if _, err := Parse(content); err == nil {
+			t.Errorf("Parse(%q) succeeded", content)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		recipe       Recipe
+		want         Recipe
+		wantProblems []string
+	}{
+		{
+			name: "repairs lists, fractions and tags",
+			recipe: Recipe{
+				Title:        "  Pancakes ",
+				Ingredients:  "- 1½ cups flour\n• 2 eggs",
+				Instructions: "Step 1: Mix.\n2) Fry.",
+				Notes:        " Serve warm. ",
+				Tags:         []string{"breakfast", "Breakfast", "unknown"},
+			},
+			want: Recipe{
+				Title:        "Pancakes",
+				Ingredients:  "* 1 1/2 cups flour\n* 2 eggs",
+				Instructions: "1. Mix.\n2. Fry.",
+				Notes:        "Serve warm.",
+				Tags:         []string{"Breakfast"},
+				CookStages:   []*CookStage{},
+			},
+		},
+		{
+			name: "reports what it cannot repair",
+			recipe: Recipe{
+				Ingredients:  "flour and eggs",
+				Instructions: "mix and fry",
+			},
+			want: Recipe{
+				Ingredients:  "flour and eggs",
+				Instructions: "mix and fry",
+				Tags:         []string{},
+				CookStages:   []*CookStage{},
+			},
+			wantProblems: []string{
+				"the title is empty",
+				"the ingredients are not a Markdown unordered list",
+				"the instructions are not a Markdown ordered list",
+			},
+		},
+		{
+			name: "drops empty cook stages and fixes their values",
+			recipe: Recipe{
+				Title:        "Roast",
+				Ingredients:  "* beef",
+				Instructions: "1. Roast.",
+				CookStages: []*CookStage{
+					nil,
+					{Method: "oven", TempDegF: 0, DurationMinutes: 0},
+					{Method: "campfire", TempDegF: 2000, DurationMinutes: 90, Note: strings.Repeat("a", MaxCookStageNoteLength+1)},
+				},
+			},
+			want: Recipe{
+				Title:            "Roast",
+				Ingredients:      "* beef",
+				Instructions:     "1. Roast.",
+				Tags:             []string{},
+				TotalTimeMinutes: 90,
+				CookStages: []*CookStage{
+					{Method: "other", TempDegF: MaxCookTempDegF, DurationMinutes: 90, Note: strings.Repeat("a", MaxCookStageNoteLength-1) + "…"},
+				},
+			},
+		},
+		{
+			name: "derives a total shorter than its parts",
+			recipe: Recipe{
+				Title:            "Bread",
+				Ingredients:      "* flour",
+				Instructions:     "1. Bake.",
+				PrepTimeMinutes:  20,
+				RestTimeMinutes:  120,
+				TotalTimeMinutes: 30,
+				CookStages:       []*CookStage{{Method: "oven", TempDegF: 450, DurationMinutes: 40}},
+			},
+			want: Recipe{
+				Title:            "Bread",
+				Ingredients:      "* flour",
+				Instructions:     "1. Bake.",
+				Tags:             []string{},
+				PrepTimeMinutes:  20,
+				RestTimeMinutes:  120,
+				TotalTimeMinutes: 180,
+				CookStages:       []*CookStage{{Method: "oven", TempDegF: 450, DurationMinutes: 40}},
+			},
+		},
+		{
+			name: "keeps a longer total and trims the yield",
+			recipe: Recipe{
+				Title:            "Stock",
+				Ingredients:      "* bones",
+				Instructions:     "1. Simmer.",
+				Yield:            "  " + strings.Repeat("y", MaxYieldLength+5),
+				TotalTimeMinutes: 600,
+				Servings:         8,
+			},
+			want: Recipe{
+				Title:            "Stock",
+				Ingredients:      "* bones",
+				Instructions:     "1. Simmer.",
+				Yield:            strings.Repeat("y", MaxYieldLength-1) + "…",
+				Tags:             []string{},
+				CookStages:       []*CookStage{},
+				TotalTimeMinutes: 600,
+				Servings:         8,
+			},
+		},
+	}
+
+	allowedTags := []string{"Breakfast", "Dinner"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recipe := test.recipe
+			problems := Normalize(&recipe, allowedTags)
+			if !reflect.DeepEqual(recipe, test.want) {
+				t.Errorf("got  %+v\nwant %+v", recipe, test.want)
+			}
+			if !reflect.DeepEqual(problems, test.wantProblems) {
+				t.Errorf("problems = %q, want %q", problems, test.wantProblems)
+			}
+		})
+	}
+}
+
+func TestNormalizeKeepsAtMostMaxCookStages(t *testing.T) {
+	recipe := Recipe{}
+	for i := 0; i < MaxCookStages+3; i++ {
+		recipe.CookStages = append(recipe.CookStages, &CookStage{Method: "oven", DurationMinutes: 1})
+	}
+	Normalize(&recipe, nil)
+	if len(recipe.CookStages) != MaxCookStages {
+		t.Errorf("kept %d stages, want %d", len(recipe.CookStages), MaxCookStages)
+	}
+}
+
+func TestNormalizeList(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		ordered bool
+		want    string
+	}{
+		{"bullets", "- a\n+ b\n• c\n· d\n▪ e", false, "* a\n* b\n* c\n* d\n* e"},
+		{"numbers as bullets", "1. a\n2) b", false, "* a\n* b"},
+		{"renumbers", "3. a\n3. b\n* c", true, "1. a\n2. b\n3. c"},
+		{"step prefixes", "Step 1: a\nstep 2. b\nSTEP 3 c", true, "1. a\n2. b\n3. c"},
+		{"restarts after a heading", "1. a\n\n## Sauce\n1. b\n2. c", true, "1. a\n\n## Sauce\n1. b\n2. c"},
+		{"blank lines do not restart", "1. a\n\n1. b", true, "1. a\n\n2. b"},
+		{"trims the text", "\n  *   a  \r\nnot an item  \n", false, "* a\nnot an item"},
+		{"leaves other lines alone", "just text", true, "just text"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeList(test.text, test.ordered); got != test.want {
+				t.Errorf("normalizeList(%q, %v) = %q, want %q", test.text, test.ordered, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplaceUnicodeFractions(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"½ cup", "1/2 cup"},
+		{"1½ cups", "1 1/2 cups"},
+		{"2 ¾ tsp", "2 3/4 tsp"},
+		{"⅛ and ⅞", "1/8 and 7/8"},
+		{"1⁄3 cup", "1/3 cup"},
+		{"3½-4¼", "3 1/2-4 1/4"},
+		{"no fractions", "no fractions"},
+	}
+
+	for _, test := range tests {
+		if got := ReplaceUnicodeFractions(test.text); got != test.want {
+			t.Errorf("ReplaceUnicodeFractions(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestFilterAllowedTags(t *testing.T) {
+	got := FilterAllowedTags([]string{" dinner", "DINNER", "Lunch", "breakfast"}, []string{"Breakfast", "Dinner"})
+	if want := []string{"Dinner", "Breakfast"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterAllowedTags = %q, want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 5, "too …"},
+		{"350°F in the oven", 4, "350…"},
+		{"½½½½", 3, "½½…"},
+		{"crème", 4, "crè…"},
+		{"abc", 0, ""},
+	}
+	for _, tt := range tests {
+		got := Truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > tt.n {
+			t.Errorf("Truncate(%q, %d) = %q is not valid UTF-8 within the limit", tt.s, tt.n, got)
+		}
+	}
+}
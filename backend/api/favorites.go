@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"encore.dev/beta/auth"
+	"encore.dev/types/uuid"
+)
+
+type FavoriteResponse struct {
+	Favorite bool `json:"favorite"`
+}
+
+type CookLogEntry struct {
+	Id       string `json:"id"`
+	RecipeId string `json:"recipe_id"`
+	CookedOn string `json:"cooked_on"` // YYYY-MM-DD, defaults to today
+	Servings int16  `json:"servings"`  // 0 when not recorded
+	Rating   int16  `json:"rating"`    // 1-5, 0 when not rated
+	Note     string `json:"note"`
+}
+
+type CookedRecipe struct {
+	Recipe       *RecipeCard `json:"recipe"`
+	TimesCooked  int         `json:"times_cooked"`
+	LastCookedOn string      `json:"last_cooked_on"`
+}
+
+type CookedRecipesResponse struct {
+	Recipes []*CookedRecipe `json:"recipes"`
+}
+
+// ToggleFavorite adds the recipe to the caller's favorites, or removes it
+// if it is already a favorite.
+//
+//encore:api auth method=POST path=/api/favorites/:recipeId
+func ToggleFavorite(ctx context.Context, recipeId string) (*FavoriteResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := checkRecipeExists(ctx, recipeId); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM recipe_favorite
+		WHERE profile_id = $1 AND recipe_id = $2
+	`, string(authResult), recipeId)
+	if err != nil {
+		return nil, fmt.Errorf("error removing favorite: %w", err)
+	}
+	if result.RowsAffected() > 0 {
+		return &FavoriteResponse{Favorite: false}, nil
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO recipe_favorite (profile_id, recipe_id)
+		VALUES ($1, $2)
+		ON CONFLICT (profile_id, recipe_id) DO NOTHING
+	`, string(authResult), recipeId)
+	if err != nil {
+		return nil, fmt.Errorf("error adding favorite: %w", err)
+	}
+
+	return &FavoriteResponse{Favorite: true}, nil
+}
+
+//encore:api auth method=GET path=/api/favorites tag:read
+func GetMyFavorites(ctx context.Context) (*RecipeListResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	recipeCards, err := queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
+		FROM recipe_favorite f
+		INNER JOIN recipe r ON f.recipe_id = r.id
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE `+recipeListableCondition+` AND f.profile_id = $1
+		ORDER BY f.created_at DESC
+	`, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecipeListResponse{Recipes: recipeCards}, nil
+}
+
+//encore:api auth method=POST path=/api/cook-log
+func AddCookLogEntry(ctx context.Context, entry *CookLogEntry) (*CookLogEntry, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if entry.Rating < 0 || entry.Rating > 5 {
+		return nil, fmt.Errorf("rating must be between 1 and 5")
+	}
+	if entry.Servings < 0 {
+		return nil, fmt.Errorf("servings must be positive")
+	}
+
+	if err := checkRecipeExists(ctx, entry.RecipeId); err != nil {
+		return nil, err
+	}
+
+	entryId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating cook log ID: %w", err)
+	}
+	entry.Id = entryId.String()
+
+	err = db.QueryRow(ctx, `
+		INSERT INTO cook_log (id, profile_id, recipe_id, cooked_on, servings, rating, note)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, '')::date, CURRENT_DATE), NULLIF($5, 0), NULLIF($6, 0), $7)
+		RETURNING cooked_on::text
+	`, entry.Id, string(authResult), entry.RecipeId, entry.CookedOn, entry.Servings, entry.Rating, entry.Note).Scan(&entry.CookedOn)
+	if err != nil {
+		return nil, fmt.Errorf("error saving cook log entry: %w", err)
+	}
+
+	return entry, nil
+}
+
+//encore:api auth method=GET path=/api/cook-log/recent tag:read
+func GetRecentlyCooked(ctx context.Context) (*CookedRecipesResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	cookedRecipes, err := queryCookedRecipes(ctx, string(authResult), "last_cooked_on DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookedRecipesResponse{Recipes: cookedRecipes}, nil
+}
+
+//encore:api auth method=GET path=/api/cook-log/most-cooked tag:read
+func GetMostCooked(ctx context.Context) (*CookedRecipesResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	cookedRecipes, err := queryCookedRecipes(ctx, string(authResult), "times_cooked DESC, last_cooked_on DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookedRecipesResponse{Recipes: cookedRecipes}, nil
+}
+
+// queryCookedRecipes summarises the profile's cook log per recipe.
+// orderBy is a fixed ORDER BY clause supplied by the caller, never user input.
+func queryCookedRecipes(ctx context.Context, profileId string, orderBy string) ([]*CookedRecipe, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+recipeCardColumns+`,
+		       COUNT(c.id) AS times_cooked, MAX(c.cooked_on)::text AS last_cooked_on
+		FROM cook_log c
+		INNER JOIN recipe r ON c.recipe_id = r.id
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE c.profile_id = $1
+		GROUP BY r.id, p.username
+		ORDER BY `+orderBy+`
+		LIMIT 50
+	`, profileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cookedRecipes []*CookedRecipe
+	for rows.Next() {
+		cr := &CookedRecipe{Recipe: &RecipeCard{}}
+		dest := append(recipeCardDest(cr.Recipe), &cr.TimesCooked, &cr.LastCookedOn)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		cookedRecipes = append(cookedRecipes, cr)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return cookedRecipes, nil
+}
+
+// loadRecipeUserState fills in the caller's favorite flag and last-cooked
+// date on a recipe. It is a no-op for anonymous callers.
+func loadRecipeUserState(ctx context.Context, recipe *Recipe) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil
+	}
+
+	return db.QueryRow(ctx, `
+		SELECT
+			EXISTS (
+				SELECT 1
+				FROM recipe_favorite
+				WHERE profile_id = $1 AND recipe_id = $2
+			),
+			COALESCE((
+				SELECT MAX(cooked_on)::text
+				FROM cook_log
+				WHERE profile_id = $1 AND recipe_id = $2
+			), '')
+	`, string(authResult), recipe.Id).Scan(&recipe.IsFavorite, &recipe.LastCookedOn)
+}
+
+func checkRecipeExists(ctx context.Context, recipeId string) error {
+	var exists bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM recipe
+			WHERE id = $1
+		)
+	`, recipeId).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("recipe not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+)
+
+const (
+	defaultFeedPageSize = 20
+	maxFeedPageSize     = 100
+)
+
+type FollowingResponse struct {
+	Usernames []string `json:"usernames"`
+}
+
+type FeedParams struct {
+	// Before and BeforeId return items after this position in the feed,
+	// which is ordered by update time and then recipe ID. Pass the previous
+	// response's NextBefore and NextBeforeId to fetch the next page.
+	Before   time.Time `query:"before"`
+	BeforeId string    `query:"before_id"`
+	Limit    int       `query:"limit"`
+}
+
+type FeedItem struct {
+	Recipe    *RecipeCard `json:"recipe"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type FeedResponse struct {
+	Items []*FeedItem `json:"items"`
+	// NextBefore and NextBeforeId are the cursor for the next page.
+	// NextBefore is nil when there are no more items.
+	NextBefore   *time.Time `json:"next_before"`
+	NextBeforeId string     `json:"next_before_id"`
+}
+
+//encore:api auth method=POST path=/api/follows/:username
+func Follow(ctx context.Context, username string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	followeeId, err := getProfileIdByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if followeeId == string(authResult) {
+		return fmt.Errorf("cannot follow yourself")
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO profile_follow (follower_id, followee_id)
+		VALUES ($1, $2)
+		ON CONFLICT (follower_id, followee_id) DO NOTHING
+	`, string(authResult), followeeId)
+	if err != nil {
+		return fmt.Errorf("error following profile: %w", err)
+	}
+
+	return nil
+}
+
+//encore:api auth method=DELETE path=/api/follows/:username
+func Unfollow(ctx context.Context, username string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	_, err := db.Exec(ctx, `
+		DELETE FROM profile_follow f
+		USING profile p
+		WHERE f.followee_id = p.id AND f.follower_id = $1 AND LOWER(p.username) = LOWER($2)
+	`, string(authResult), username)
+	if err != nil {
+		return fmt.Errorf("error unfollowing profile: %w", err)
+	}
+
+	return nil
+}
+
+//encore:api auth method=GET path=/api/follows tag:read
+func GetFollowing(ctx context.Context) (*FollowingResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT p.username
+		FROM profile_follow f
+		INNER JOIN profile p ON f.followee_id = p.id
+		WHERE f.follower_id = $1
+		ORDER BY p.username
+	`, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &FollowingResponse{Usernames: usernames}, nil
+}
+
+// GetFeed returns recently created or updated recipes from the profiles the
+// caller follows, newest first.
+//
+//encore:api auth method=GET path=/api/feed tag:read
+func GetFeed(ctx context.Context, params *FeedParams) (*FeedResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultFeedPageSize
+	}
+	if limit > maxFeedPageSize {
+		limit = maxFeedPageSize
+	}
+
+	before := params.Before
+	if before.IsZero() {
+		before = time.Now()
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT `+recipeCardColumns+`, r.created_at, r.updated_at
+		FROM profile_follow f
+		INNER JOIN recipe r ON r.profile_id = f.followee_id
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE `+recipeListableCondition+` AND f.follower_id = $1 AND (r.updated_at, r.id) < ($2, $3)
+		ORDER BY r.updated_at DESC, r.id DESC
+		LIMIT $4
+	`, string(authResult), before, params.BeforeId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := &FeedResponse{Items: []*FeedItem{}}
+	for rows.Next() {
+		item := &FeedItem{Recipe: &RecipeCard{}}
+		dest := append(recipeCardDest(item.Recipe), &item.CreatedAt, &item.UpdatedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		response.Items = append(response.Items, item)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	// Many recipes can share an update time, e.g. those stamped by a
+	// migration, so the cursor includes the ID to avoid skipping any.
+	if len(response.Items) == limit {
+		last := response.Items[len(response.Items)-1]
+		response.NextBefore = &last.UpdatedAt
+		response.NextBeforeId = last.Recipe.Id
+	}
+
+	return response, nil
+}
+
+func getProfileIdByUsername(ctx context.Context, username string) (string, error) {
+	var profileId string
+	err := db.QueryRow(ctx, `
+		SELECT id
+		FROM profile
+		WHERE LOWER(username) = LOWER($1)
+	`, username).Scan(&profileId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("profile not found")
+		}
+		return "", err
+	}
+
+	return profileId, nil
+}
@@ -0,0 +1,583 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+)
+
+// Household member roles. Owners manage membership and invite codes,
+// editors can add and edit household recipes, viewers can only read them.
+const (
+	HouseholdRoleOwner  = "owner"
+	HouseholdRoleEditor = "editor"
+	HouseholdRoleViewer = "viewer"
+)
+
+const (
+	// householdInviteDays is how long an invite code works after it is
+	// generated.
+	householdInviteDays = 7
+	// maxHouseholdJoinsPerHour limits attempts to join a household,
+	// including ones with a wrong code, so invite codes cannot be guessed.
+	maxHouseholdJoinsPerHour = 10
+)
+
+type Household struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	InviteCode string `json:"invite_code,omitempty"` // only returned to owners
+	// InviteExpiresAt is when the invite code stops working; only returned
+	// to owners.
+	InviteExpiresAt *time.Time         `json:"invite_expires_at,omitempty"`
+	Role            string             `json:"role"`
+	Members         []*HouseholdMember `json:"members"`
+}
+
+type HouseholdMember struct {
+	ProfileId string `json:"profile_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+}
+
+type HouseholdMembership struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type SaveHouseholdRequest struct {
+	Name string `json:"name"`
+}
+
+type UpdateHouseholdMemberRequest struct {
+	Role string `json:"role"`
+}
+
+//encore:api auth method=POST path=/api/households
+func CreateHousehold(ctx context.Context, req *SaveHouseholdRequest) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	householdId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating household ID: %w", err)
+	}
+
+	inviteCode, err := generateInviteCode()
+	if err != nil {
+		return nil, fmt.Errorf("error generating invite code: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO household (id, name, invite_code, invite_expires_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(days => $4))
+	`, householdId.String(), req.Name, inviteCode, householdInviteDays)
+	if err != nil {
+		return nil, fmt.Errorf("error creating household: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO household_member (household_id, profile_id, role)
+		VALUES ($1, $2, $3)
+	`, householdId.String(), string(authResult), HouseholdRoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("error adding household owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getHousehold(ctx, householdId.String(), string(authResult))
+}
+
+//encore:api auth method=GET path=/api/households/:id tag:read
+func GetHousehold(ctx context.Context, id string) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	return getHousehold(ctx, id, string(authResult))
+}
+
+//encore:api auth method=POST path=/api/households/:id
+func UpdateHousehold(ctx context.Context, id string, req *SaveHouseholdRequest) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireHouseholdRole(ctx, id, string(authResult), HouseholdRoleOwner); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(ctx, `UPDATE household SET name = $2 WHERE id = $1`, id, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error updating household: %w", err)
+	}
+
+	return getHousehold(ctx, id, string(authResult))
+}
+
+//encore:api auth method=DELETE path=/api/households/:id
+func DeleteHousehold(ctx context.Context, id string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	if err := requireHouseholdRole(ctx, id, string(authResult), HouseholdRoleOwner); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(ctx, `DELETE FROM household WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting household: %w", err)
+	}
+
+	return nil
+}
+
+//encore:api auth method=POST path=/api/households/:id/invite-code
+func RegenerateHouseholdInviteCode(ctx context.Context, id string) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireHouseholdRole(ctx, id, string(authResult), HouseholdRoleOwner); err != nil {
+		return nil, err
+	}
+
+	inviteCode, err := generateInviteCode()
+	if err != nil {
+		return nil, fmt.Errorf("error generating invite code: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		UPDATE household
+		SET invite_code = $2, invite_expires_at = NOW() + make_interval(days => $3)
+		WHERE id = $1
+	`, id, inviteCode, householdInviteDays)
+	if err != nil {
+		return nil, fmt.Errorf("error updating invite code: %w", err)
+	}
+
+	return getHousehold(ctx, id, string(authResult))
+}
+
+// JoinHousehold adds the caller to the household with the given invite code.
+// New members join as viewers; an owner can promote them afterwards. Expired
+// codes are rejected like unknown ones.
+//
+//encore:api auth method=POST path=/api/household-invites/:code
+func JoinHousehold(ctx context.Context, code string) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := recordHouseholdJoinAttempt(ctx, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	var householdId string
+	err := db.QueryRow(ctx, `
+		SELECT id
+		FROM household
+		WHERE invite_code = UPPER($1) AND invite_expires_at > NOW()
+	`, code).Scan(&householdId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("invalid invite code")
+		}
+		return nil, err
+	}
+
+	// Joining a household you already belong to leaves your role untouched.
+	_, err = db.Exec(ctx, `
+		INSERT INTO household_member (household_id, profile_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (household_id, profile_id) DO NOTHING
+	`, householdId, string(authResult), HouseholdRoleViewer)
+	if err != nil {
+		return nil, fmt.Errorf("error joining household: %w", err)
+	}
+
+	return getHousehold(ctx, householdId, string(authResult))
+}
+
+//encore:api auth method=POST path=/api/households/:id/members/:profileId
+func UpdateHouseholdMember(ctx context.Context, id string, profileId string, req *UpdateHouseholdMemberRequest) (*Household, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if !isValidHouseholdRole(req.Role) {
+		return nil, fmt.Errorf("invalid role: %s", req.Role)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	roles, err := lockHouseholdMembers(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireLockedHouseholdRole(roles, string(authResult), HouseholdRoleOwner); err != nil {
+		return nil, err
+	}
+	if _, ok := roles[profileId]; !ok {
+		return nil, fmt.Errorf("household member not found")
+	}
+	if req.Role != HouseholdRoleOwner {
+		if err := ensureAnotherOwner(roles, profileId); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE household_member
+		SET role = $3
+		WHERE household_id = $1 AND profile_id = $2
+	`, id, profileId, req.Role)
+	if err != nil {
+		return nil, fmt.Errorf("error updating household member: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getHousehold(ctx, id, string(authResult))
+}
+
+// RemoveHouseholdMember removes a member from a household. Owners can remove
+// anyone; any member can remove themselves to leave the household.
+//
+//encore:api auth method=DELETE path=/api/households/:id/members/:profileId
+func RemoveHouseholdMember(ctx context.Context, id string, profileId string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roles, err := lockHouseholdMembers(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if string(authResult) != profileId {
+		if err := requireLockedHouseholdRole(roles, string(authResult), HouseholdRoleOwner); err != nil {
+			return err
+		}
+	}
+	if err := ensureAnotherOwner(roles, profileId); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM household_member
+		WHERE household_id = $1 AND profile_id = $2
+	`, id, profileId)
+	if err != nil {
+		return fmt.Errorf("error removing household member: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func getHousehold(ctx context.Context, householdId string, profileId string) (*Household, error) {
+	household := &Household{Id: householdId}
+
+	err := db.QueryRow(ctx, `
+		SELECT h.name, h.invite_code, h.invite_expires_at, m.role
+		FROM household h
+		INNER JOIN household_member m ON m.household_id = h.id
+		WHERE h.id = $1 AND m.profile_id = $2
+	`, householdId, profileId).Scan(&household.Name, &household.InviteCode, &household.InviteExpiresAt, &household.Role)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("household not found")
+		}
+		return nil, err
+	}
+
+	if household.Role != HouseholdRoleOwner {
+		household.InviteCode = ""
+		household.InviteExpiresAt = nil
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT m.profile_id, p.username, m.role
+		FROM household_member m
+		INNER JOIN profile p ON m.profile_id = p.id
+		WHERE m.household_id = $1
+		ORDER BY m.joined_at
+	`, householdId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member := &HouseholdMember{}
+		if err := rows.Scan(&member.ProfileId, &member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+		household.Members = append(household.Members, member)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return household, nil
+}
+
+func getHouseholdMemberships(ctx context.Context, profileId string) ([]*HouseholdMembership, error) {
+	rows, err := db.Query(ctx, `
+		SELECT h.id, h.name, m.role
+		FROM household_member m
+		INNER JOIN household h ON m.household_id = h.id
+		WHERE m.profile_id = $1
+		ORDER BY h.name
+	`, profileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []*HouseholdMembership
+	for rows.Next() {
+		hm := &HouseholdMembership{}
+		if err := rows.Scan(&hm.Id, &hm.Name, &hm.Role); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, hm)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return memberships, nil
+}
+
+// getHouseholdRole returns the profile's role in the household, or an empty
+// string if the profile is not a member.
+func getHouseholdRole(ctx context.Context, householdId string, profileId string) (string, error) {
+	var role string
+	err := db.QueryRow(ctx, `
+		SELECT role
+		FROM household_member
+		WHERE household_id = $1 AND profile_id = $2
+	`, householdId, profileId).Scan(&role)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+// requireHouseholdRole returns an error unless the profile's role in the
+// household is at least minRole (owner > editor > viewer).
+func requireHouseholdRole(ctx context.Context, householdId string, profileId string, minRole string) error {
+	role, err := getHouseholdRole(ctx, householdId, profileId)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return fmt.Errorf("household not found")
+	}
+	if householdRoleRank(role) < householdRoleRank(minRole) {
+		return fmt.Errorf("not authorized")
+	}
+
+	return nil
+}
+
+// requireOwnerOrHouseholdRole checks access to something owned either by a
+// single profile or by a household, such as a meal plan entry or a shopping
+// list. Exactly one of ownerId and householdId is set. Things owned by
+// another profile are reported as notFound.
+func requireOwnerOrHouseholdRole(ctx context.Context, ownerId *string, householdId *string, profileId string, minRole string, notFound error) error {
+	if householdId != nil {
+		return requireHouseholdRole(ctx, *householdId, profileId, minRole)
+	}
+	if ownerId == nil || *ownerId != profileId {
+		return notFound
+	}
+
+	return nil
+}
+
+// lockHouseholdMembers locks the household's member rows until the
+// transaction ends, so concurrent role changes and removals are applied one
+// after the other, and returns each member's role by profile ID.
+func lockHouseholdMembers(ctx context.Context, tx *sqldb.Tx, householdId string) (map[string]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT profile_id, role
+		FROM household_member
+		WHERE household_id = $1
+		FOR UPDATE
+	`, householdId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string]string{}
+	for rows.Next() {
+		var profileId, role string
+		if err := rows.Scan(&profileId, &role); err != nil {
+			return nil, err
+		}
+		roles[profileId] = role
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return roles, nil
+}
+
+// requireLockedHouseholdRole is requireHouseholdRole for member roles
+// returned by lockHouseholdMembers.
+func requireLockedHouseholdRole(roles map[string]string, profileId string, minRole string) error {
+	role, ok := roles[profileId]
+	if !ok {
+		return fmt.Errorf("household not found")
+	}
+	if householdRoleRank(role) < householdRoleRank(minRole) {
+		return fmt.Errorf("not authorized")
+	}
+
+	return nil
+}
+
+// ensureAnotherOwner guards against a household being left without an owner
+// when profileId is demoted or removed. roles must come from
+// lockHouseholdMembers in the transaction that makes the change.
+func ensureAnotherOwner(roles map[string]string, profileId string) error {
+	if roles[profileId] != HouseholdRoleOwner {
+		return nil
+	}
+	for otherId, role := range roles {
+		if otherId != profileId && role == HouseholdRoleOwner {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("a household must have at least one owner")
+}
+
+// recordHouseholdJoinAttempt logs an attempt to join a household, or returns
+// an error if the profile has made too many recently. Attempts are recorded
+// before the code is checked, so wrong guesses count too.
+func recordHouseholdJoinAttempt(ctx context.Context, profileId string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('household_join:' || $1))`, profileId)
+	if err != nil {
+		return err
+	}
+
+	var lastHour int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM household_join_attempt
+		WHERE profile_id = $1 AND attempted_at > NOW() - INTERVAL '1 hour'
+	`, profileId).Scan(&lastHour)
+	if err != nil {
+		return err
+	}
+
+	if lastHour >= maxHouseholdJoinsPerHour {
+		return fmt.Errorf("too many attempts to join a household, please try again later")
+	}
+
+	// Attempts older than the window are no longer needed.
+	_, err = tx.Exec(ctx, `
+		DELETE FROM household_join_attempt
+		WHERE profile_id = $1 AND attempted_at <= NOW() - INTERVAL '1 hour'
+	`, profileId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO household_join_attempt (profile_id)
+		VALUES ($1)
+	`, profileId)
+	if err != nil {
+		return fmt.Errorf("error recording join attempt: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func householdRoleRank(role string) int {
+	switch role {
+	case HouseholdRoleOwner:
+		return 3
+	case HouseholdRoleEditor:
+		return 2
+	case HouseholdRoleViewer:
+		return 1
+	}
+	return 0
+}
+
+func isValidHouseholdRole(role string) bool {
+	return householdRoleRank(role) > 0
+}
+
+func generateInviteCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+
+	// Register the decoders for the other formats we accept.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Upload limits for recipe images.
+const (
+	maxUploadFiles     = 5
+	maxUploadFileBytes = 10 << 20
+	// maxImagePixels rejects images that would take too much memory to
+	// decode, whatever their file size.
+	maxImagePixels = 50_000_000
+
+	// OpenAI scales images to fit 2048x2048 and then to 768px on the short
+	// side before analysing them, so anything larger only costs upload time.
+	maxImageLongSide  = 2048
+	maxImageShortSide = 768
+
+	uploadJPEGQuality = 85
+)
+
+// preprocessUploads validates uploaded recipe photos and re-encodes them as
+// JPEGs that are upright, no larger than the model can use, and stripped of
+// metadata. The declared MIME type is ignored in favour of the file content.
+func preprocessUploads(files []FileUpload) ([]FileUpload, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no images uploaded")
+	}
+	if len(files) > maxUploadFiles {
+		return nil, fmt.Errorf("you can upload at most %d images", maxUploadFiles)
+	}
+
+	processed := make([]FileUpload, 0, len(files))
+	for _, file := range files {
+		content, err := preprocessImage(file.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Filename, err)
+		}
+		processed = append(processed, FileUpload{
+			Filename: file.Filename,
+			Content:  base64.StdEncoding.EncodeToString(content),
+			MimeType: "image/jpeg",
+		})
+	}
+
+	return processed, nil
+}
+
+func preprocessImage(encoded string) ([]byte, error) {
+	if base64.StdEncoding.DecodedLen(len(encoded)) > maxUploadFileBytes+3 {
+		return nil, fmt.Errorf("image cannot be larger than %d MB", maxUploadFileBytes>>20)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file content")
+	}
+	if len(data) > maxUploadFileBytes {
+		return nil, fmt.Errorf("image cannot be larger than %d MB", maxUploadFileBytes>>20)
+	}
+
+	if isHEIF(data) {
+		// There is no HEIC decoder available to us. Browsers that can decode
+		// HEIC convert it to JPEG before uploading (see toUploadableImage).
+		return nil, fmt.Errorf("HEIC images are not supported by this browser, please export the photo as JPEG")
+	}
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, fmt.Errorf("file is not a JPEG, PNG or GIF image")
+	}
+
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not read image")
+	}
+	if imgCfg.Width*imgCfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image dimensions are too large")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not read image")
+	}
+
+	img := downscaleImage(src, maxImageLongSide, maxImageShortSide)
+	img = orientImage(img, exifOrientation(data))
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: uploadJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isHEIF reports whether data is a HEIF/HEIC file, which is an ISO media
+// file with an "ftyp" box naming a HEIF brand.
+func isHEIF(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(data[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// downscaleImage shrinks the image to fit the long and short side limits,
+// averaging the source pixels covered by each destination pixel, and
+// flattens it onto a white background, since JPEG has no transparency. It
+// reads the decoded image directly, so the full-size image is never copied.
+func downscaleImage(src image.Image, maxLong int, maxShort int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	long, short := w, h
+	if h > w {
+		long, short = h, w
+	}
+	scale := 1.0
+	if long > maxLong {
+		scale = float64(maxLong) / float64(long)
+	}
+	if s := float64(maxShort) / float64(short); s < scale {
+		scale = s
+	}
+
+	dw, dh := w, h
+	if scale < 1 {
+		dw, dh = max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+	}
+	pixel := flatPixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb := pixel(bounds.Min.X+sx, bounds.Min.Y+sy)
+					r += pr
+					g += pg
+					b += pb
+					n++
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// flatPixelReader returns a function reading the 8-bit colour of a pixel
+// composited onto white. JPEGs decode to YCbCr and are read without going
+// through the color.Color interface; other images take the generic path.
+func flatPixelReader(src image.Image) func(x, y int) (uint32, uint32, uint32) {
+	if img, ok := src.(*image.YCbCr); ok {
+		return func(x, y int) (uint32, uint32, uint32) {
+			r, g, b := color.YCbCrToRGB(img.Y[img.YOffset(x, y)], img.Cb[img.COffset(x, y)], img.Cr[img.COffset(x, y)])
+			return uint32(r), uint32(g), uint32(b)
+		}
+	}
+
+	return func(x, y int) (uint32, uint32, uint32) {
+		// RGBA returns alpha-premultiplied 16-bit values, so adding the
+		// missing coverage composites the pixel onto white.
+		r, g, b, a := src.At(x, y).RGBA()
+		return (r + 0xffff - a) >> 8, (g + 0xffff - a) >> 8, (b + 0xffff - a) >> 8
+	}
+}
+
+// orientImage rotates and flips the image so it displays upright, given its
+// EXIF orientation (1-8).
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs a 90° clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a 90° counter-clockwise rotation
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+
+	return dst
+}
+
+// exifOrientation returns the orientation tag from a JPEG's EXIF data, or 1
+// (upright) if there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	// Walk the JPEG segments up to the start of the image data.
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation reads the orientation tag (0x0112) from the first IFD of
+// the TIFF structure embedded in EXIF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
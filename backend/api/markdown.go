@@ -0,0 +1,41 @@
+package api
+
+import "encore.app/backend/api/recipetext"
+
+// RenderedText is a Markdown field rendered for display. Html is safe to
+// insert into a page as is: any HTML in the source is escaped and only
+// http, https and mailto links are kept.
+type RenderedText struct {
+	Html string `json:"html"`
+	Text string `json:"text"`
+}
+
+type RenderedRecipe struct {
+	Ingredients  RenderedText `json:"ingredients"`
+	Instructions RenderedText `json:"instructions"`
+	Notes        RenderedText `json:"notes"`
+}
+
+// renderRecipe renders the recipe's Markdown fields.
+func renderRecipe(recipe *Recipe) *RenderedRecipe {
+	return &RenderedRecipe{
+		Ingredients:  renderMarkdown(recipe.Ingredients),
+		Instructions: renderMarkdown(recipe.Instructions),
+		Notes:        renderMarkdown(recipe.Notes),
+	}
+}
+
+func renderMarkdown(source string) RenderedText {
+	html, text := recipetext.RenderMarkdown(source)
+	return RenderedText{Html: html, Text: text}
+}
+
+// cleanMarkdown and renderInlineText are used to turn single lines into
+// plain text.
+func cleanMarkdown(source string) string {
+	return recipetext.CleanMarkdown(source)
+}
+
+func renderInlineText(text string) string {
+	return recipetext.RenderInlineText(text)
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"encore.dev/beta/auth"
+	"encore.dev/types/uuid"
+)
+
+const (
+	// mealPlanDateLayout is the format of meal plan dates, e.g. 2024-03-01.
+	mealPlanDateLayout = "2006-01-02"
+	// defaultMealPlanDays is how many days are listed when no end date is
+	// given, starting with the first.
+	defaultMealPlanDays   = 7
+	maxMealPlanDays       = 62
+	maxMealPlanPerDay     = 20
+	maxMealPlanNoteLength = 500
+)
+
+var errMealPlanEntryNotFound = errors.New("meal plan entry not found")
+
+// MealPlanEntry is a meal planned for a day: a recipe, a note such as
+// "leftovers", or both. Entries with a household ID are shared with the
+// household.
+type MealPlanEntry struct {
+	Id          string  `json:"id"`
+	HouseholdId *string `json:"household_id"`
+	Date        string  `json:"date"` // YYYY-MM-DD
+	Meal        string  `json:"meal"` // breakfast, lunch, dinner or snack
+	RecipeId    *string `json:"recipe_id"`
+	Note        string  `json:"note"`
+	// Recipe is set when the entry has a recipe the caller can see.
+	Recipe *RecipeCard `json:"recipe,omitempty"`
+}
+
+type MealPlanParams struct {
+	// Household lists the household's meal plan instead of the caller's own.
+	Household string `query:"household"`
+	// From and To are the first and last day listed, as YYYY-MM-DD. From
+	// defaults to today and To to a week from From.
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+type MealPlanResponse struct {
+	Entries []*MealPlanEntry `json:"entries"`
+}
+
+// GetMealPlan lists the meals planned between two days, in the caller's own
+// meal plan or a household's.
+//
+//encore:api auth method=GET path=/api/meal-plan tag:read
+func GetMealPlan(ctx context.Context, params *MealPlanParams) (*MealPlanResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := checkHouseholdScope(ctx, params.Household); err != nil {
+		return nil, err
+	}
+
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	if params.From != "" {
+		var err error
+		if from, err = time.Parse(mealPlanDateLayout, params.From); err != nil {
+			return nil, fmt.Errorf("invalid from date: %s", params.From)
+		}
+	}
+	to := from.AddDate(0, 0, defaultMealPlanDays-1)
+	if params.To != "" {
+		var err error
+		if to, err = time.Parse(mealPlanDateLayout, params.To); err != nil {
+			return nil, fmt.Errorf("invalid to date: %s", params.To)
+		}
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("to date must not be before from date")
+	}
+	if to.Sub(from) >= maxMealPlanDays*24*time.Hour {
+		return nil, fmt.Errorf("cannot list more than %d days", maxMealPlanDays)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, household_id, planned_on::text, meal, recipe_id, note
+		FROM meal_plan_entry
+		WHERE CASE WHEN $1 = '' THEN profile_id = $2 ELSE household_id = $1 END
+		  AND planned_on BETWEEN $3::date AND $4::date
+		ORDER BY planned_on, array_position(ARRAY['breakfast', 'lunch', 'dinner', 'snack'], meal), created_at
+	`, params.Household, string(authResult), from.Format(mealPlanDateLayout), to.Format(mealPlanDateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*MealPlanEntry{}
+	for rows.Next() {
+		entry := &MealPlanEntry{}
+		if err := rows.Scan(&entry.Id, &entry.HouseholdId, &entry.Date, &entry.Meal, &entry.RecipeId, &entry.Note); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	if err := loadMealPlanRecipes(ctx, entries, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	return &MealPlanResponse{Entries: entries}, nil
+}
+
+// AddMealPlanEntry plans a meal, in the caller's own meal plan or, when a
+// household ID is given, in the household's. Household meal plans can be
+// changed by editors and owners.
+//
+//encore:api auth method=POST path=/api/meal-plan
+func AddMealPlanEntry(ctx context.Context, entry *MealPlanEntry) (*MealPlanEntry, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if entry.HouseholdId != nil {
+		if err := requireHouseholdRole(ctx, *entry.HouseholdId, string(authResult), HouseholdRoleEditor); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateMealPlanEntry(ctx, entry, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	var ownerId *string
+	if entry.HouseholdId == nil {
+		profileId := string(authResult)
+		ownerId = &profileId
+	}
+
+	var plannedThatDay int
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM meal_plan_entry
+		WHERE (profile_id = $1 OR household_id = $2) AND planned_on = $3::date
+	`, ownerId, entry.HouseholdId, entry.Date).Scan(&plannedThatDay)
+	if err != nil {
+		return nil, err
+	}
+	if plannedThatDay >= maxMealPlanPerDay {
+		return nil, fmt.Errorf("cannot plan more than %d meals on one day", maxMealPlanPerDay)
+	}
+
+	entryId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating meal plan entry ID: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO meal_plan_entry (id, profile_id, household_id, planned_on, meal, recipe_id, note)
+		VALUES ($1, $2, $3, $4::date, $5, $6, $7)
+	`, entryId.String(), ownerId, entry.HouseholdId, entry.Date, entry.Meal, entry.RecipeId, entry.Note)
+	if err != nil {
+		return nil, fmt.Errorf("error saving meal plan entry: %w", err)
+	}
+
+	return getMealPlanEntry(ctx, entryId.String(), string(authResult))
+}
+
+// UpdateMealPlanEntry moves a planned meal to another day or meal, or
+// changes its recipe or note. The entry stays in the meal plan it was
+// added to.
+//
+//encore:api auth method=POST path=/api/meal-plan/:id
+func UpdateMealPlanEntry(ctx context.Context, id string, entry *MealPlanEntry) (*MealPlanEntry, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireMealPlanAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+	if err := validateMealPlanEntry(ctx, entry, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(ctx, `
+		UPDATE meal_plan_entry
+		SET planned_on = $2::date, meal = $3, recipe_id = $4, note = $5
+		WHERE id = $1
+	`, id, entry.Date, entry.Meal, entry.RecipeId, entry.Note)
+	if err != nil {
+		return nil, fmt.Errorf("error updating meal plan entry: %w", err)
+	}
+
+	return getMealPlanEntry(ctx, id, string(authResult))
+}
+
+//encore:api auth method=DELETE path=/api/meal-plan/:id
+func DeleteMealPlanEntry(ctx context.Context, id string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	if err := requireMealPlanAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(ctx, `DELETE FROM meal_plan_entry WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting meal plan entry: %w", err)
+	}
+
+	return nil
+}
+
+func validateMealPlanEntry(ctx context.Context, entry *MealPlanEntry, profileId string) error {
+	if _, err := time.Parse(mealPlanDateLayout, entry.Date); err != nil {
+		return fmt.Errorf("invalid date: %s", entry.Date)
+	}
+	switch entry.Meal {
+	case "breakfast", "lunch", "dinner", "snack":
+	default:
+		return fmt.Errorf("invalid meal: %s", entry.Meal)
+	}
+	if utf8.RuneCountInString(entry.Note) > maxMealPlanNoteLength {
+		return fmt.Errorf("note must be at most %d characters", maxMealPlanNoteLength)
+	}
+	if entry.RecipeId == nil && entry.Note == "" {
+		return fmt.Errorf("a recipe or a note is required")
+	}
+	if entry.RecipeId != nil {
+		return checkRecipeVisible(ctx, *entry.RecipeId, profileId)
+	}
+
+	return nil
+}
+
+// requireMealPlanAccess checks that the profile may see the entry, or change
+// it when minRole is editor.
+func requireMealPlanAccess(ctx context.Context, id string, profileId string, minRole string) error {
+	var ownerId, householdId *string
+	err := db.QueryRow(ctx, `
+		SELECT profile_id, household_id
+		FROM meal_plan_entry
+		WHERE id = $1
+	`, id).Scan(&ownerId, &householdId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errMealPlanEntryNotFound
+		}
+		return err
+	}
+
+	return requireOwnerOrHouseholdRole(ctx, ownerId, householdId, profileId, minRole, errMealPlanEntryNotFound)
+}
+
+func getMealPlanEntry(ctx context.Context, id string, profileId string) (*MealPlanEntry, error) {
+	entry := &MealPlanEntry{Id: id}
+	err := db.QueryRow(ctx, `
+		SELECT household_id, planned_on::text, meal, recipe_id, note
+		FROM meal_plan_entry
+		WHERE id = $1
+	`, id).Scan(&entry.HouseholdId, &entry.Date, &entry.Meal, &entry.RecipeId, &entry.Note)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMealPlanEntryNotFound
+		}
+		return nil, err
+	}
+
+	if err := loadMealPlanRecipes(ctx, []*MealPlanEntry{entry}, profileId); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// loadMealPlanRecipes fills in the recipe cards of the entries. Recipes that
+// have since been hidden are left out unless they belong to the profile.
+func loadMealPlanRecipes(ctx context.Context, entries []*MealPlanEntry, profileId string) error {
+	var recipeIds []string
+	for _, entry := range entries {
+		if entry.RecipeId != nil {
+			recipeIds = append(recipeIds, *entry.RecipeId)
+		}
+	}
+	if len(recipeIds) == 0 {
+		return nil
+	}
+
+	recipeCards, err := queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		WHERE r.id = ANY($1) AND (`+recipeListableCondition+` OR r.profile_id = $2)
+	`, recipeIds, profileId)
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[string]*RecipeCard, len(recipeCards))
+	for _, rc := range recipeCards {
+		byId[rc.Id] = rc
+	}
+	for _, entry := range entries {
+		if entry.RecipeId != nil {
+			entry.Recipe = byId[*entry.RecipeId]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+-- Create household table; a household owns a shared recipe library
+CREATE TABLE household (
+    id TEXT PRIMARY KEY,
+    name TEXT DEFAULT '' NOT NULL,
+    invite_code TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+-- Members of a household and their role within it
+CREATE TABLE household_member (
+    household_id TEXT NOT NULL REFERENCES household(id) ON DELETE CASCADE,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    joined_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (household_id, profile_id)
+);
+
+CREATE INDEX idx_household_member_profile_id ON household_member(profile_id);
+
+-- Recipes may optionally belong to a household. Deleting the household
+-- leaves the recipes with the profile that created them.
+ALTER TABLE recipe
+ADD COLUMN household_id TEXT NULL REFERENCES household(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_recipe_household_id ON recipe(household_id);
@@ -0,0 +1,21 @@
+-- Recipes a profile has marked as a favorite
+CREATE TABLE recipe_favorite (
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (profile_id, recipe_id)
+);
+
+-- Private log of each time a profile cooked a recipe
+CREATE TABLE cook_log (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    cooked_on DATE DEFAULT CURRENT_DATE NOT NULL,
+    servings SMALLINT CHECK (servings > 0) NULL,
+    rating SMALLINT CHECK (rating BETWEEN 1 AND 5) NULL,
+    note TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_cook_log_profile_id_recipe_id ON cook_log(profile_id, recipe_id);
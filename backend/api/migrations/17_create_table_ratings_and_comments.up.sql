@@ -0,0 +1,22 @@
+-- Public star ratings, one per profile per recipe
+CREATE TABLE recipe_rating (
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (recipe_id, profile_id)
+);
+
+-- Threaded comments; replies reference their parent comment
+CREATE TABLE recipe_comment (
+    id TEXT PRIMARY KEY,
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    parent_id TEXT NULL REFERENCES recipe_comment(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    hidden BOOLEAN DEFAULT FALSE NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_recipe_comment_recipe_id ON recipe_comment(recipe_id, created_at);
+CREATE INDEX idx_recipe_comment_profile_id ON recipe_comment(profile_id, created_at);
@@ -0,0 +1,18 @@
+-- Track when recipes are created and last updated. Existing recipes are
+-- stamped with the time of this migration.
+ALTER TABLE recipe
+ADD COLUMN created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+ADD COLUMN updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL;
+
+CREATE INDEX idx_recipe_profile_id_updated_at ON recipe(profile_id, updated_at DESC);
+
+-- Profiles following other profiles
+CREATE TABLE profile_follow (
+    follower_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    followee_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX idx_profile_follow_followee_id ON profile_follow(followee_id);
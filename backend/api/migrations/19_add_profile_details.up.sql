@@ -0,0 +1,12 @@
+-- Public profile details
+ALTER TABLE profile
+ADD COLUMN display_name TEXT DEFAULT '' NOT NULL,
+ADD COLUMN bio TEXT DEFAULT '' NOT NULL,
+ADD COLUMN avatar_url TEXT DEFAULT '' NOT NULL,
+ADD COLUMN links TEXT[] DEFAULT '{}' NOT NULL;
+
+-- Remember which recipe a copy was made from, so originals can show how often they were copied
+ALTER TABLE recipe
+ADD COLUMN copied_from_id TEXT NULL REFERENCES recipe(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_recipe_copied_from_id ON recipe(copied_from_id);
@@ -0,0 +1,10 @@
+-- Usernames a profile used previously, so old /:username/:slug links keep
+-- working. A retired username stays reserved for the profile that used it.
+CREATE TABLE username_history (
+    username VARCHAR(128) NOT NULL,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    changed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_username_history_username ON username_history(LOWER(username));
+CREATE INDEX idx_username_history_profile_id ON username_history(profile_id, changed_at);
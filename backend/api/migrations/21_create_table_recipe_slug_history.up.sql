@@ -0,0 +1,11 @@
+-- Slugs a recipe used previously, so old /:username/:slug links keep working.
+-- A retired slug stays reserved for the recipe that used it.
+CREATE TABLE recipe_slug_history (
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    slug TEXT NOT NULL,
+    changed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_recipe_slug_history_profile_id_slug ON recipe_slug_history(profile_id, LOWER(slug));
+CREATE INDEX idx_recipe_slug_history_recipe_id ON recipe_slug_history(recipe_id);
@@ -0,0 +1,21 @@
+-- Banned profiles are excluded from listings and cannot publish content
+ALTER TABLE profile
+ADD COLUMN banned_at TIMESTAMPTZ NULL,
+ADD COLUMN ban_reason TEXT DEFAULT '' NOT NULL;
+
+-- Hidden recipes are excluded from listings and only visible to their owner
+ALTER TABLE recipe
+ADD COLUMN hidden BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- Audit log of actions taken by admins
+CREATE TABLE moderation_action (
+    id TEXT PRIMARY KEY,
+    admin_id VARCHAR(128) NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('recipe', 'profile')),
+    target_id TEXT NOT NULL,
+    reason TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_moderation_action_created_at ON moderation_action(created_at DESC);
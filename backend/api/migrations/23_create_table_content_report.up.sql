@@ -0,0 +1,22 @@
+-- Reports filed by users against public recipes or profiles
+CREATE TABLE content_report (
+    id TEXT PRIMARY KEY,
+    reporter_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    target_type TEXT NOT NULL CHECK (target_type IN ('recipe', 'profile')),
+    target_id TEXT NOT NULL,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'copyright', 'offensive', 'other')),
+    details TEXT DEFAULT '' NOT NULL,
+    status TEXT DEFAULT 'open' NOT NULL CHECK (status IN ('open', 'resolved', 'dismissed')),
+    resolution_note TEXT DEFAULT '' NOT NULL,
+    resolved_by VARCHAR(128) NULL,
+    resolved_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+-- A user can only have one open report per target
+CREATE UNIQUE INDEX idx_content_report_open_target ON content_report(reporter_id, target_type, target_id) WHERE status = 'open';
+CREATE INDEX idx_content_report_status ON content_report(status, created_at);
+
+-- Profiles hidden after too many reports are excluded from listings
+ALTER TABLE profile
+ADD COLUMN hidden BOOLEAN DEFAULT FALSE NOT NULL;
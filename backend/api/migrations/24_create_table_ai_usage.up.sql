@@ -0,0 +1,23 @@
+-- One row per call to the AI recipe import, inserted before the call is made
+-- so concurrent imports count towards the limits
+CREATE TABLE ai_usage (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('image', 'text')),
+    model TEXT DEFAULT '' NOT NULL,
+    prompt_tokens INT DEFAULT 0 NOT NULL,
+    completion_tokens INT DEFAULT 0 NOT NULL,
+    total_tokens INT DEFAULT 0 NOT NULL,
+    status TEXT DEFAULT 'pending' NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_ai_usage_profile_created_at ON ai_usage(profile_id, created_at);
+
+-- Per-profile overrides of the default monthly AI import quota
+CREATE TABLE ai_quota (
+    profile_id VARCHAR(128) PRIMARY KEY REFERENCES profile(id) ON DELETE CASCADE,
+    monthly_imports INT NOT NULL CHECK (monthly_imports >= 0),
+    monthly_tokens INT NOT NULL CHECK (monthly_tokens >= 0),
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
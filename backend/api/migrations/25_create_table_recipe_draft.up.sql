@@ -0,0 +1,13 @@
+-- Recipes extracted by the AI import that are waiting to be reviewed
+CREATE TABLE recipe_draft (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    source TEXT NOT NULL CHECK (source IN ('image', 'text')),
+    recipe JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_recipe_draft_profile_id ON recipe_draft(profile_id, created_at);
+CREATE INDEX idx_recipe_draft_expires_at ON recipe_draft(expires_at);
@@ -0,0 +1,19 @@
+-- Tags the AI import may assign: global tags have no profile, custom tags
+-- belong to a single profile
+CREATE TABLE tag (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NULL REFERENCES profile(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_tag_profile_name ON tag(COALESCE(profile_id, ''), LOWER(name));
+
+INSERT INTO tag (id, name) VALUES
+    ('bread', 'Bread'),
+    ('breakfast', 'Breakfast'),
+    ('dessert', 'Dessert'),
+    ('dinner', 'Dinner'),
+    ('dressing', 'Dressing'),
+    ('mix', 'Mix'),
+    ('snack', 'Snack');
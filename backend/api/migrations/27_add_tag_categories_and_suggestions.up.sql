@@ -0,0 +1,45 @@
+-- Tags are grouped so a recipe can have one of each kind
+ALTER TABLE tag
+ADD COLUMN category TEXT DEFAULT 'other' NOT NULL
+    CHECK (category IN ('course', 'cuisine', 'diet', 'main_ingredient', 'other'));
+
+UPDATE tag SET category = 'course' WHERE id IN ('bread', 'breakfast', 'dessert', 'dinner', 'dressing', 'snack');
+
+INSERT INTO tag (id, name, category) VALUES
+    ('american', 'American', 'cuisine'),
+    ('chinese', 'Chinese', 'cuisine'),
+    ('french', 'French', 'cuisine'),
+    ('indian', 'Indian', 'cuisine'),
+    ('italian', 'Italian', 'cuisine'),
+    ('mexican', 'Mexican', 'cuisine'),
+    ('dairy-free', 'Dairy-Free', 'diet'),
+    ('gluten-free', 'Gluten-Free', 'diet'),
+    ('vegan', 'Vegan', 'diet'),
+    ('vegetarian', 'Vegetarian', 'diet'),
+    ('beef', 'Beef', 'main_ingredient'),
+    ('chicken', 'Chicken', 'main_ingredient'),
+    ('fish', 'Fish', 'main_ingredient'),
+    ('pasta', 'Pasta', 'main_ingredient'),
+    ('pork', 'Pork', 'main_ingredient'),
+    ('vegetables', 'Vegetables', 'main_ingredient');
+
+-- Tags suggested for existing recipes by the auto-tagging job, waiting for
+-- the owner to accept or reject them
+CREATE TABLE tag_suggestion (
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    status TEXT DEFAULT 'pending' NOT NULL CHECK (status IN ('pending', 'accepted', 'rejected')),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    decided_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (recipe_id, tag)
+);
+
+CREATE INDEX idx_tag_suggestion_pending ON tag_suggestion(recipe_id) WHERE status = 'pending';
+
+-- When the auto-tagging job last looked at the recipe
+ALTER TABLE recipe
+ADD COLUMN auto_tagged_at TIMESTAMPTZ NULL;
+
+-- Auto-tagging usage is tracked but does not count towards import quotas
+ALTER TABLE ai_usage DROP CONSTRAINT ai_usage_kind_check;
+ALTER TABLE ai_usage ADD CONSTRAINT ai_usage_kind_check CHECK (kind IN ('image', 'text', 'tagging'));
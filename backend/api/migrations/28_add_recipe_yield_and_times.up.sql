@@ -0,0 +1,7 @@
+-- Yield and the times besides cooking, 0 or empty when unknown
+ALTER TABLE recipe
+ADD COLUMN servings SMALLINT DEFAULT 0 NOT NULL,
+ADD COLUMN yield TEXT DEFAULT '' NOT NULL,
+ADD COLUMN prep_time_minutes SMALLINT DEFAULT 0 NOT NULL,
+ADD COLUMN rest_time_minutes SMALLINT DEFAULT 0 NOT NULL,
+ADD COLUMN total_time_minutes SMALLINT DEFAULT 0 NOT NULL;
@@ -0,0 +1,17 @@
+-- Ordered cooking stages, e.g. 425°F for 20 minutes then 400°F for 40.
+-- recipe.cook_temp_deg_f and cook_time_minutes are derived from them.
+CREATE TABLE recipe_cook_stage (
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    method TEXT NOT NULL CHECK (method IN ('oven', 'stovetop', 'grill', 'air_fryer', 'slow_cooker', 'pressure_cooker', 'microwave', 'other')),
+    temp_deg_f SMALLINT DEFAULT 0 NOT NULL,
+    duration_minutes SMALLINT DEFAULT 0 NOT NULL,
+    note TEXT DEFAULT '' NOT NULL,
+    PRIMARY KEY (recipe_id, position)
+);
+
+-- Existing recipes get a single stage from their legacy fields
+INSERT INTO recipe_cook_stage (recipe_id, position, method, temp_deg_f, duration_minutes)
+SELECT id, 0, CASE WHEN cook_temp_deg_f > 0 THEN 'oven' ELSE 'other' END, cook_temp_deg_f, cook_time_minutes
+FROM recipe
+WHERE cook_temp_deg_f > 0 OR cook_time_minutes > 0;
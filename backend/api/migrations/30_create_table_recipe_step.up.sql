@@ -0,0 +1,15 @@
+-- Instructions parsed into steps for cook mode, rebuilt whenever the recipe
+-- has changed since steps_parsed_at
+CREATE TABLE recipe_step (
+    recipe_id TEXT NOT NULL REFERENCES recipe(id) ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    section TEXT DEFAULT '' NOT NULL,
+    text TEXT NOT NULL,
+    timers JSONB DEFAULT '[]' NOT NULL,
+    temp_deg_f SMALLINT DEFAULT 0 NOT NULL,
+    ingredients TEXT[] DEFAULT '{}' NOT NULL,
+    PRIMARY KEY (recipe_id, position)
+);
+
+ALTER TABLE recipe
+ADD COLUMN steps_parsed_at TIMESTAMPTZ NULL;
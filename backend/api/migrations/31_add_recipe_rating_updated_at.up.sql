@@ -0,0 +1,7 @@
+-- When a rating was last set, so rating changes can be rate limited
+ALTER TABLE recipe_rating
+ADD COLUMN updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL;
+
+UPDATE recipe_rating SET updated_at = created_at;
+
+CREATE INDEX idx_recipe_rating_profile_id ON recipe_rating(profile_id, updated_at);
@@ -0,0 +1,3 @@
+-- Markdown cleaning no longer strips tag-shaped text and decodes entities
+-- escaped more than once, so parse every recipe's steps again
+UPDATE recipe SET steps_parsed_at = NULL;
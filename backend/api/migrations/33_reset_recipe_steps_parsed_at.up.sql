@@ -0,0 +1,3 @@
+-- Instructions without a list now have steps, and "12 c" is no longer read
+-- as a temperature, so parse every recipe's steps again
+UPDATE recipe SET steps_parsed_at = NULL;
@@ -0,0 +1,4 @@
+-- How many times auto-tagging the recipe has failed, so the job can give up
+-- on recipes that keep failing
+ALTER TABLE recipe
+ADD COLUMN auto_tag_attempts SMALLINT DEFAULT 0 NOT NULL;
@@ -0,0 +1,5 @@
+-- Whether the user picked the username, rather than keeping the one
+-- suggested when the profile was created on first login. Replacing a
+-- suggested username is not kept in username_history.
+ALTER TABLE profile
+ADD COLUMN username_chosen BOOLEAN DEFAULT TRUE NOT NULL;
@@ -0,0 +1,13 @@
+-- Invite codes stop working a week after they are generated; owners can
+-- generate a new one. Codes that exist already get a week from now.
+ALTER TABLE household
+ADD COLUMN invite_expires_at TIMESTAMPTZ DEFAULT NOW() + INTERVAL '7 days' NOT NULL;
+
+-- Every attempt to join a household with an invite code, successful or
+-- not, so guessing codes can be rate limited
+CREATE TABLE household_join_attempt (
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    attempted_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_household_join_attempt_profile_id ON household_join_attempt(profile_id, attempted_at);
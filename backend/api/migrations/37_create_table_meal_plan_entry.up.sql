@@ -0,0 +1,16 @@
+-- Meals planned for a day. An entry belongs either to a single profile or
+-- to a household, where every member sees it and editors can change it.
+CREATE TABLE meal_plan_entry (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NULL REFERENCES profile(id) ON DELETE CASCADE,
+    household_id TEXT NULL REFERENCES household(id) ON DELETE CASCADE,
+    planned_on DATE NOT NULL,
+    meal TEXT NOT NULL CHECK (meal IN ('breakfast', 'lunch', 'dinner', 'snack')),
+    recipe_id TEXT NULL REFERENCES recipe(id) ON DELETE SET NULL,
+    note TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    CHECK ((profile_id IS NULL) <> (household_id IS NULL))
+);
+
+CREATE INDEX idx_meal_plan_entry_profile_id ON meal_plan_entry(profile_id, planned_on);
+CREATE INDEX idx_meal_plan_entry_household_id ON meal_plan_entry(household_id, planned_on);
@@ -0,0 +1,26 @@
+-- Shopping lists. Like meal plan entries, a list belongs either to a single
+-- profile or to a household.
+CREATE TABLE shopping_list (
+    id TEXT PRIMARY KEY,
+    profile_id VARCHAR(128) NULL REFERENCES profile(id) ON DELETE CASCADE,
+    household_id TEXT NULL REFERENCES household(id) ON DELETE CASCADE,
+    name TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    CHECK ((profile_id IS NULL) <> (household_id IS NULL))
+);
+
+CREATE INDEX idx_shopping_list_profile_id ON shopping_list(profile_id);
+CREATE INDEX idx_shopping_list_household_id ON shopping_list(household_id);
+
+-- Items on a shopping list, optionally noting the recipe they were added
+-- from
+CREATE TABLE shopping_list_item (
+    id TEXT PRIMARY KEY,
+    shopping_list_id TEXT NOT NULL REFERENCES shopping_list(id) ON DELETE CASCADE,
+    text TEXT NOT NULL,
+    checked BOOLEAN DEFAULT FALSE NOT NULL,
+    recipe_id TEXT NULL REFERENCES recipe(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_shopping_list_item_shopping_list_id ON shopping_list_item(shopping_list_id);
@@ -0,0 +1,8 @@
+-- Every time a profile sets a rating, so the rating rate limit cannot be
+-- avoided by deleting a rating and rating again
+CREATE TABLE recipe_rating_attempt (
+    profile_id VARCHAR(128) NOT NULL REFERENCES profile(id) ON DELETE CASCADE,
+    attempted_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_recipe_rating_attempt_profile_id ON recipe_rating_attempt(profile_id, attempted_at);
@@ -0,0 +1,8 @@
+-- When the profile was created, so reports from brand new accounts do not
+-- count towards hiding content automatically. Profiles created before this
+-- column existed are left NULL and count as established.
+ALTER TABLE profile
+ADD COLUMN created_at TIMESTAMPTZ NULL;
+
+ALTER TABLE profile
+ALTER COLUMN created_at SET DEFAULT NOW();
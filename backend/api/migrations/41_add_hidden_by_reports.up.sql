@@ -0,0 +1,31 @@
+-- Whether hidden content was hidden automatically after reports, rather
+-- than by an admin. Only automatic hides can be restored when resolving
+-- the reports.
+ALTER TABLE recipe
+ADD COLUMN hidden_by_reports BOOLEAN DEFAULT FALSE NOT NULL;
+
+ALTER TABLE profile
+ADD COLUMN hidden_by_reports BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- Content that is hidden and was last hidden automatically
+UPDATE recipe r
+SET hidden_by_reports = TRUE
+WHERE r.hidden AND (
+    SELECT action
+    FROM moderation_action
+    WHERE target_type = 'recipe' AND target_id = r.id
+      AND action IN ('auto_hide_recipe', 'hide_recipe', 'unhide_recipe', 'restore_recipe')
+    ORDER BY created_at DESC
+    LIMIT 1
+) = 'auto_hide_recipe';
+
+UPDATE profile p
+SET hidden_by_reports = TRUE
+WHERE p.hidden AND (
+    SELECT action
+    FROM moderation_action
+    WHERE target_type = 'profile' AND target_id = p.id
+      AND action IN ('auto_hide_profile', 'hide_profile', 'restore_profile')
+    ORDER BY created_at DESC
+    LIMIT 1
+) = 'auto_hide_profile';
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"encore.app/backend/api/extraction"
+)
+
+// Limits for recipe values, shared with the AI import.
+const (
+	maxCookTimeMinutes = extraction.MaxCookTimeMinutes
+	maxCookTempDegF    = extraction.MaxCookTempDegF
+)
+
+// recipeFromExtraction converts a normalized extracted recipe. Normalizing
+// clamped every number to a range that fits the recipe's int16 fields.
+func recipeFromExtraction(extracted *extraction.Recipe) *Recipe {
+	recipe := &Recipe{
+		Title:            extracted.Title,
+		Ingredients:      extracted.Ingredients,
+		Instructions:     extracted.Instructions,
+		Notes:            extracted.Notes,
+		Servings:         int16Ptr(int16(extracted.Servings)),
+		Yield:            &extracted.Yield,
+		PrepTimeMinutes:  int16Ptr(int16(extracted.PrepTimeMinutes)),
+		RestTimeMinutes:  int16Ptr(int16(extracted.RestTimeMinutes)),
+		TotalTimeMinutes: int16Ptr(int16(extracted.TotalTimeMinutes)),
+		Tags:             extracted.Tags,
+		CookStages:       []*CookStage{},
+	}
+	for _, stage := range extracted.CookStages {
+		recipe.CookStages = append(recipe.CookStages, &CookStage{
+			Method:          stage.Method,
+			TempDegF:        int16(stage.TempDegF),
+			DurationMinutes: int16(stage.DurationMinutes),
+			Note:            stage.Note,
+		})
+	}
+	deriveLegacyCookFields(recipe)
+
+	return recipe
+}
+
+func int16Ptr(v int16) *int16 {
+	return &v
+}
+
+// repairPrompt asks the model to fix the problems extraction.Normalize found
+// in its previous answer.
+func repairPrompt(problems []string) string {
+	return fmt.Sprintf("Your previous answer had these problems: %s. Respond again with the provided schema, fixing them while following the original guidelines.",
+		strings.Join(problems, "; "))
+}
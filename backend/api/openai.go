@@ -1,12 +1,14 @@
 package api
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"strings"
+
+	"encore.dev/rlog"
+
+	"encore.app/backend/api/extraction"
 )
 
 type OpenAIRequest struct {
@@ -39,7 +41,8 @@ type Property struct {
 	Items                *Property           `json:"items,omitempty"` // Pointer for nested structure
 	Properties           map[string]Property `json:"properties,omitempty"`
 	Required             []string            `json:"required,omitempty"`
-	AdditionalProperties bool                `json:"additionalProperties,omitempty"`
+	AdditionalProperties *bool               `json:"additionalProperties,omitempty"`
+	Enum                 []string            `json:"enum,omitempty"`
 }
 
 type Message struct {
@@ -58,19 +61,29 @@ type ImageURL struct {
 }
 
 type OpenAIResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage OpenAIUsage `json:"usage"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// Model is copied from the response, since it can differ from the one requested.
+	Model string `json:"-"`
 }
 
 var secrets struct {
 	OpenApiKey string
 }
 
-const analyzeImagePrompt = `Analyze the attached recipe images. Respond with the provided schema using the following guidelines:` + promptBase
-const analyzeTextPrompt = analyzeTextPromptHeader + promptBase + analyzeTextPromptFooter
+const analyzeImagePrompt = `Analyze the attached recipe images. Respond with the provided schema using the following guidelines:`
 const analyzeTextPromptHeader = `Analyze the included recipe text. Respond with the provided schema using the following guidelines:`
 const analyzeTextPromptFooter = `
 
@@ -98,15 +111,43 @@ End with a newline (press 'Enter' after each instruction)
 
 Notes: Formatted in Markdown when present (not every recipe has notes)
 
-Tags: Assign a single tag from the following list, if relevant: [Bread, Breakfast, Dessert, Dinner, Dressing, Mix, Snack]. If none apply, leave the tag field empty.`
+Servings and yield: servings is the number of people the recipe serves. Yield describes what the recipe makes when that is given instead or as well, e.g. "2 loaves" or "24 cookies". Use 0 and an empty string when not stated.
+
+Cook stages: one entry for each stage of cooking at a different temperature or with a different method, in order, e.g. 425°F in the oven for 20 minutes then 400°F for 40 minutes is two stages. Method is one of the listed values. Use a temperature of 0 when there is no set temperature, such as simmering on the stovetop. Note briefly describes the stage, e.g. "covered" or "until golden". Leave the list empty if the recipe is not cooked.
+
+Times: all in minutes. Prep time is hands-on preparation, rest time covers any resting, rising, proofing, chilling or marinating, cook time is time in the oven or on the heat, and total time is the time from start to finish. Use 0 for any time that is not stated and cannot be worked out from the instructions.`
+
+// tagGuideline tells the model which tags it may assign, grouped by category.
+func tagGuideline(tags []*Tag) string {
+	if len(tags) == 0 {
+		return "\n\nTags: Leave the tag field empty."
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nTags: Assign every tag from the following lists that applies, usually at most one course and one cuisine. If none apply, leave the tag field empty.")
+	for _, category := range tagCategories {
+		var names []string
+		for _, t := range tags {
+			if t.Category == category && isPromptSafeTagName(t.Name) {
+				names = append(names, t.Name)
+			}
+		}
+		if len(names) > 0 {
+			fmt.Fprintf(&b, "\n%s: [%s]", strings.ReplaceAll(category, "_", " "), strings.Join(names, ", "))
+		}
+	}
+	return b.String()
+}
 
-func AnalyzeImageToRecipe(ctx context.Context, files []FileUpload) (*Recipe, error) {
+// AnalyzeImageToRecipe extracts a recipe from photos, assigning tags from the
+// given vocabulary.
+func AnalyzeImageToRecipe(ctx context.Context, files []FileUpload, tags []*Tag) (*Recipe, *OpenAIUsage, error) {
 
 	var messagesContent []Content
 
 	promptContent := Content{
 		Type: "text",
-		Text: analyzeImagePrompt + promptBase,
+		Text: analyzeImagePrompt + promptBase + tagGuideline(tags),
 	}
 	messagesContent = append(messagesContent, promptContent)
 
@@ -120,43 +161,69 @@ func AnalyzeImageToRecipe(ctx context.Context, files []FileUpload) (*Recipe, err
 		messagesContent = append(messagesContent, imageContent)
 	}
 
-	reqBody := constructOpenAIRequestBody(messagesContent)
-	openAIResp, err := submitRequestToOpenAI(ctx, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("error submitting recipe to OpenAI: %v", err)
-	}
-
-	recipe, err := parseRecipeResponse(openAIResp)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing recipe: %v", err)
-	}
-
-	return &recipe, nil
+	return extractRecipe(ctx, messagesContent, tags)
 }
 
-func AnalyzeTextToRecipe(ctx context.Context, text string) (*Recipe, error) {
+// AnalyzeTextToRecipe extracts a recipe from text, assigning tags from the
+// given vocabulary.
+func AnalyzeTextToRecipe(ctx context.Context, text string, tags []*Tag) (*Recipe, *OpenAIUsage, error) {
 	messagesContent := []Content{
 		{
 			Type: "text",
-			Text: analyzeTextPrompt + text,
+			Text: analyzeTextPromptHeader + promptBase + tagGuideline(tags) + analyzeTextPromptFooter + text,
 		},
 	}
 
-	reqBody := constructOpenAIRequestBody(messagesContent)
-	openAIResp, err := submitRequestToOpenAI(ctx, reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("error submitting recipe to OpenAI: %v", err)
-	}
+	return extractRecipe(ctx, messagesContent, tags)
+}
 
-	recipe, err := parseRecipeResponse(openAIResp)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing recipe: %v", err)
-	}
+// extractRecipe asks the model for a recipe and normalizes its answer. If
+// problems remain and re-prompting is enabled, the model is asked once more
+// to fix them. The returned usage covers every call made.
+func extractRecipe(ctx context.Context, messagesContent []Content, tags []*Tag) (*Recipe, *OpenAIUsage, error) {
+	reqBody := constructOpenAIRequestBody(messagesContent, tagNames(tags))
+	usage := &OpenAIUsage{}
+
+	for attempt := 1; ; attempt++ {
+		openAIResp, err := submitRequestToOpenAI(ctx, reqBody)
+		if err != nil {
+			return nil, usage, fmt.Errorf("error submitting recipe to OpenAI: %v", err)
+		}
+		usage.PromptTokens += openAIResp.Usage.PromptTokens
+		usage.CompletionTokens += openAIResp.Usage.CompletionTokens
+		usage.TotalTokens += openAIResp.Usage.TotalTokens
+		usage.Model = openAIResp.Model
+
+		extracted, err := extraction.Parse(openAIResp.Choices[0].Message.Content)
+		if err != nil {
+			return nil, usage, fmt.Errorf("error parsing recipe: %v", err)
+		}
 
-	return &recipe, nil
+		problems := extraction.Normalize(extracted, tagNames(tags))
+		if len(problems) == 0 || attempt > 1 || !cfg.RepromptInvalidRecipes() {
+			if len(problems) > 0 {
+				rlog.Warn("saving extracted recipe with problems", "problems", problems)
+			}
+			return recipeFromExtraction(extracted), usage, nil
+		}
+
+		rlog.Info("re-prompting for extracted recipe", "problems", problems)
+		reqBody.Messages = append(reqBody.Messages,
+			Message{
+				Role:    "assistant",
+				Content: []Content{{Type: "text", Text: openAIResp.Choices[0].Message.Content}},
+			},
+			Message{
+				Role:    "user",
+				Content: []Content{{Type: "text", Text: repairPrompt(problems)}},
+			},
+		)
+	}
 }
 
-func constructOpenAIRequestBody(messagesContent []Content) OpenAIRequest {
+// constructOpenAIRequestBody builds the extraction request. The schema only
+// allows tags from the vocabulary.
+func constructOpenAIRequestBody(messagesContent []Content, tags []string) OpenAIRequest {
 	reqBody := OpenAIRequest{
 		Model: "gpt-4o-mini",
 		Messages: []Message{
@@ -185,20 +252,45 @@ func constructOpenAIRequestBody(messagesContent []Content) OpenAIRequest {
 						"notes": {
 							Type: "string",
 						},
-						"cook_temp_deg_f": {
+						"cook_stages": {
+							Type: "array",
+							Items: &Property{
+								Type: "object",
+								Properties: map[string]Property{
+									"method":           {Type: "string", Enum: cookMethods},
+									"temp_deg_f":       {Type: "integer"},
+									"duration_minutes": {Type: "integer"},
+									"note":             {Type: "string"},
+								},
+								Required:             []string{"method", "temp_deg_f", "duration_minutes", "note"},
+								AdditionalProperties: new(bool),
+							},
+						},
+						"servings": {
+							Type: "integer",
+						},
+						"yield": {
+							Type: "string",
+						},
+						"prep_time_minutes": {
+							Type: "integer",
+						},
+						"rest_time_minutes": {
 							Type: "integer",
 						},
-						"cook_time_minutes": {
+						"total_time_minutes": {
 							Type: "integer",
 						},
 						"tags": {
 							Type: "array",
 							Items: &Property{
 								Type: "string",
+								Enum: tags,
 							},
 						},
 					},
-					Required:             []string{"title", "ingredients", "instructions", "notes", "cook_temp_deg_f", "cook_time_minutes", "tags"},
+					Required: []string{"title", "ingredients", "instructions", "notes", "cook_stages",
+						"servings", "yield", "prep_time_minutes", "rest_time_minutes", "total_time_minutes", "tags"},
 					AdditionalProperties: false,
 				},
 				Strict: true,
@@ -210,39 +302,9 @@ func constructOpenAIRequestBody(messagesContent []Content) OpenAIRequest {
 }
 
 func submitRequestToOpenAI(ctx context.Context, reqBody OpenAIRequest) (*OpenAIResponse, error) {
-	// Convert request to JSON
-	jsonData, err := json.Marshal(reqBody)
+	body, err := openAI.ChatCompletion(ctx, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling request: %w", err)
-	}
-
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", secrets.OpenApiKey))
-
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
-	}
-
-	// Check if status code is not 200
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
 	// Parse response
@@ -257,19 +319,3 @@ func submitRequestToOpenAI(ctx context.Context, reqBody OpenAIRequest) (*OpenAIR
 
 	return &openAIResp, nil
 }
-
-func parseRecipeResponse(response *OpenAIResponse) (Recipe, error) {
-	// Assuming the recipe data is in the first choice
-	content := response.Choices[0].Message.Content
-
-	// Create an instance of Recipe to hold the parsed data
-	var recipe Recipe
-
-	// Parse JSON content into the Recipe struct
-	err := json.Unmarshal([]byte(content), &recipe)
-	if err != nil {
-		return Recipe{}, fmt.Errorf("error parsing recipe: %v", err)
-	}
-
-	return recipe, err
-}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"encore.dev/config"
+	"encore.dev/rlog"
+
+	"encore.app/backend/api/openaiclient"
+)
+
+type Config struct {
+	// OpenAIBaseURL is the base URL of the OpenAI compatible API, without a
+	// trailing slash.
+	OpenAIBaseURL config.String
+
+	// RepromptInvalidRecipes asks the model once more when an extracted
+	// recipe still has problems after normalization.
+	RepromptInvalidRecipes config.Bool
+}
+
+var cfg = config.Load[*Config]()
+
+const (
+	openAICallTimeout = 60 * time.Second
+	openAIMaxAttempts = 3
+	openAIBaseBackoff = 500 * time.Millisecond
+	openAIMaxBackoff  = 10 * time.Second
+
+	// The breaker opens after this many consecutive failed calls and lets a
+	// single call through to probe the provider once the cooldown has passed.
+	openAIBreakerThreshold = 5
+	openAIBreakerCooldown  = 30 * time.Second
+)
+
+var errAIUnavailable = openaiclient.ErrUnavailable
+
+var openAI = &openaiclient.Client{
+	BaseURL:     cfg.OpenAIBaseURL,
+	APIKey:      func() string { return secrets.OpenApiKey },
+	HTTPClient:  &http.Client{},
+	CallTimeout: openAICallTimeout,
+	MaxAttempts: openAIMaxAttempts,
+	BaseBackoff: openAIBaseBackoff,
+	MaxBackoff:  openAIMaxBackoff,
+	Breaker:     openaiclient.NewCircuitBreaker(openAIBreakerThreshold, openAIBreakerCooldown),
+	LogInfo:     rlog.Info,
+	LogWarn:     rlog.Warn,
+}
@@ -0,0 +1,81 @@
+package openaiclient
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker counts consecutive failures. Once threshold is reached it
+// rejects calls until cooldown has passed, then lets one probe call through;
+// the probe's outcome closes the breaker again or restarts the cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// breakerTicket is handed out for each allowed call. Only the ticket of the
+// probe call may clear the probe slot, so calls that were let through while
+// the breaker was closed cannot start a second probe when they finish.
+type breakerTicket struct {
+	probe bool
+	done  bool
+}
+
+func (b *CircuitBreaker) allow() (*breakerTicket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return &breakerTicket{}, true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return nil, false
+	}
+	b.probing = true
+	return &breakerTicket{probe: true}, true
+}
+
+func (b *CircuitBreaker) success(t *breakerTicket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.finish(t)
+}
+
+func (b *CircuitBreaker) failure(t *breakerTicket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.threshold && (t.probe || !b.probing) {
+		b.openedAt = b.now()
+	}
+	b.finish(t)
+}
+
+// release frees the probe slot if the call ended without an outcome.
+func (b *CircuitBreaker) release(t *breakerTicket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.finish(t)
+}
+
+func (b *CircuitBreaker) finish(t *breakerTicket) {
+	if t.probe && !t.done {
+		b.probing = false
+	}
+	t.done = true
+}
@@ -0,0 +1,131 @@
+package openaiclient
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestBreaker returns a breaker with a clock the test moves forward.
+func newTestBreaker(threshold int) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(threshold, 30*time.Second)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+// openBreaker records threshold failures.
+func openBreaker(t *testing.T, b *CircuitBreaker) {
+	t.Helper()
+	for i := 0; i < b.threshold; i++ {
+		ticket, ok := b.allow()
+		if !ok {
+			t.Fatalf("call %d rejected before the breaker opened", i+1)
+		}
+		b.failure(ticket)
+		b.release(ticket)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(3)
+
+	for i := 0; i < 2; i++ {
+		ticket, _ := b.allow()
+		b.failure(ticket)
+	}
+	ticket, ok := b.allow()
+	if !ok {
+		t.Fatal("breaker opened before the threshold")
+	}
+	// A success resets the count.
+	b.success(ticket)
+
+	openBreaker(t, b)
+	if _, ok := b.allow(); ok {
+		t.Error("breaker allowed a call while open")
+	}
+}
+
+func TestBreakerLetsOneProbeThroughAfterCooldown(t *testing.T) {
+	b, now := newTestBreaker(2)
+	openBreaker(t, b)
+
+	*now = now.Add(29 * time.Second)
+	if _, ok := b.allow(); ok {
+		t.Fatal("breaker allowed a call during the cooldown")
+	}
+
+	*now = now.Add(time.Second)
+	probe, ok := b.allow()
+	if !ok || !probe.probe {
+		t.Fatal("breaker did not allow a probe after the cooldown")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("breaker allowed a second call while probing")
+	}
+
+	b.success(probe)
+	b.release(probe)
+	for i := 0; i < 3; i++ {
+		if ticket, ok := b.allow(); !ok || ticket.probe {
+			t.Fatal("breaker did not close after a successful probe")
+		}
+	}
+}
+
+func TestBreakerReopensWhenProbeFails(t *testing.T) {
+	b, now := newTestBreaker(2)
+	openBreaker(t, b)
+
+	*now = now.Add(30 * time.Second)
+	probe, _ := b.allow()
+	b.failure(probe)
+	b.release(probe)
+
+	if _, ok := b.allow(); ok {
+		t.Fatal("breaker allowed a call right after the probe failed")
+	}
+	*now = now.Add(30 * time.Second)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("breaker did not allow a new probe after another cooldown")
+	}
+}
+
+func TestBreakerReleasesAbandonedProbe(t *testing.T) {
+	b, now := newTestBreaker(2)
+	openBreaker(t, b)
+
+	*now = now.Add(30 * time.Second)
+	probe, _ := b.allow()
+	b.release(probe)
+
+	if _, ok := b.allow(); !ok {
+		t.Fatal("breaker kept the probe slot of a call that ended without an outcome")
+	}
+}
+
+func TestBreakerIgnoresOtherCallsWhileProbing(t *testing.T) {
+	b, now := newTestBreaker(2)
+
+	// A call let through while the breaker was closed.
+	slow, _ := b.allow()
+	openBreaker(t, b)
+
+	*now = now.Add(30 * time.Second)
+	probe, _ := b.allow()
+
+	// The slow call finishing must not free the probe slot.
+	b.release(slow)
+	if _, ok := b.allow(); ok {
+		t.Fatal("a non-probe call freed the probe slot")
+	}
+	b.failure(slow)
+	if _, ok := b.allow(); ok {
+		t.Fatal("a non-probe failure freed the probe slot")
+	}
+
+	b.success(probe)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("breaker did not close after the probe succeeded")
+	}
+}
@@ -0,0 +1,222 @@
+// Package openaiclient calls an OpenAI compatible chat completions API with
+// a timeout per attempt, retries on rate limiting and server errors, and
+// fails fast through a circuit breaker while the provider is down. It has
+// no Encore dependencies so it can be tested with plain go test.
+package openaiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"encore.app/backend/api/extraction"
+)
+
+const maxResponseSize = 1 << 20
+
+// ErrUnavailable is returned while the provider is down or unreachable.
+var ErrUnavailable = errors.New("the recipe import service is temporarily unavailable, please try again later")
+
+// Logger receives a message and key-value pairs for each attempt, e.g.
+// rlog.Info and rlog.Warn.
+type Logger func(msg string, keysAndValues ...interface{})
+
+// Client is an OpenAI chat completions client. BaseURL and APIKey are
+// functions so they can be read from config and secrets on every call.
+type Client struct {
+	BaseURL     func() string
+	APIKey      func() string
+	HTTPClient  *http.Client
+	CallTimeout time.Duration
+	MaxAttempts int
+	BaseBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. If the provider asks
+	// for a longer wait with Retry-After, the call gives up instead.
+	MaxBackoff time.Duration
+	Breaker    *CircuitBreaker
+
+	// LogInfo and LogWarn are optional.
+	LogInfo Logger
+	LogWarn Logger
+}
+
+// attemptError is the outcome of a failed attempt. The provider's response
+// body is only logged, never returned to the user.
+type attemptError struct {
+	status     int // 0 if no response was received
+	retryable  bool
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *attemptError) Error() string { return e.err.Error() }
+
+// ChatCompletion posts the request to /chat/completions and returns the
+// response body. Errors are safe to show to the user.
+func (c *Client) ChatCompletion(ctx context.Context, reqBody interface{}) ([]byte, error) {
+	ticket, ok := c.Breaker.allow()
+	if !ok {
+		c.warn("openai circuit breaker open, failing fast")
+		return nil, ErrUnavailable
+	}
+	// Free the probe slot if the call ends without reaching the provider,
+	// e.g. because the caller went away.
+	defer c.Breaker.release(ticket)
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	var lastErr *attemptError
+	for attempt := 1; attempt <= c.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if err := sleepContext(ctx, c.backoff(attempt-1, lastErr.retryAfter)); err != nil {
+				return nil, err
+			}
+		}
+
+		body, attemptErr := c.attempt(ctx, jsonData, attempt)
+		if attemptErr == nil {
+			c.Breaker.success(ticket)
+			return body, nil
+		}
+		lastErr = attemptErr
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !attemptErr.retryable {
+			break
+		}
+		// Retrying sooner than the provider asked would only be rejected
+		// again.
+		if attemptErr.retryAfter > c.MaxBackoff {
+			c.warn("openai retry-after exceeds max backoff, giving up", "attempt", attempt, "retry_after_ms", attemptErr.retryAfter.Milliseconds())
+			break
+		}
+	}
+
+	// Only an unreachable or failing provider counts towards opening the
+	// breaker; a response to a bad request shows it is up.
+	if lastErr.status == 0 || lastErr.status >= 500 {
+		c.Breaker.failure(ticket)
+	} else {
+		c.Breaker.success(ticket)
+	}
+
+	switch {
+	case lastErr.status == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("the recipe import service is busy, please try again later")
+	case lastErr.retryable:
+		return nil, ErrUnavailable
+	}
+	return nil, fmt.Errorf("the recipe import service rejected the request (status %d)", lastErr.status)
+}
+
+func (c *Client) attempt(ctx context.Context, jsonData []byte, attempt int) ([]byte, *attemptError) {
+	ctx, cancel := context.WithTimeout(ctx, c.CallTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL()+"/chat/completions", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, &attemptError{err: fmt.Errorf("error creating request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey()))
+
+	start := time.Now()
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.warn("openai request failed", "attempt", attempt, "latency_ms", time.Since(start).Milliseconds(), "err", err)
+		return nil, &attemptError{retryable: true, err: fmt.Errorf("error making request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		c.warn("openai response read failed", "attempt", attempt, "status", resp.StatusCode, "latency_ms", latency, "err", err)
+		return nil, &attemptError{status: resp.StatusCode, retryable: true, err: fmt.Errorf("error reading response: %w", err)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		c.warn("openai request unsuccessful", "attempt", attempt, "status", resp.StatusCode, "latency_ms", latency, "body", extraction.Truncate(string(body), 500))
+		return nil, &attemptError{
+			status:     resp.StatusCode,
+			retryable:  isRetryableStatus(resp.StatusCode),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			err:        fmt.Errorf("API request failed with status %d", resp.StatusCode),
+		}
+	}
+
+	if c.LogInfo != nil {
+		c.LogInfo("openai request succeeded", "attempt", attempt, "status", resp.StatusCode, "latency_ms", latency)
+	}
+	return body, nil
+}
+
+func (c *Client) warn(msg string, keysAndValues ...interface{}) {
+	if c.LogWarn != nil {
+		c.LogWarn(msg, keysAndValues...)
+	}
+}
+
+// backoff returns the delay before the given retry, honouring the server's
+// Retry-After if it sent one. ChatCompletion does not retry when Retry-After
+// is longer than MaxBackoff, so it is never shortened.
+func (c *Client) backoff(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := c.BaseBackoff << (retry - 1)
+	// Add up to 50% jitter so concurrent callers do not retry in lockstep.
+	delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	if delay > c.MaxBackoff {
+		delay = c.MaxBackoff
+	}
+	return delay
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date, returning 0 if it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package openaiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for the server that retries quickly.
+func newTestClient(server *httptest.Server) *Client {
+	return &Client{
+		BaseURL:     func() string { return server.URL },
+		APIKey:      func() string { return "test-key" },
+		HTTPClient:  server.Client(),
+		CallTimeout: time.Second,
+		MaxAttempts: 3,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		Breaker:     NewCircuitBreaker(5, time.Minute),
+	}
+}
+
+// newStatusServer responds with the statuses in turn, repeating the last
+// one, and counts the requests it receives.
+func newStatusServer(t *testing.T, calls *int32, statuses ...int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if r.URL.Path != "/chat/completions" || r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		status := statuses[min(n, len(statuses))-1]
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status":` + http.StatusText(status) + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestChatCompletionRetriesServerErrors(t *testing.T) {
+	var calls int32
+	server := newStatusServer(t, &calls, http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK)
+
+	body, err := newTestClient(server).ChatCompletion(context.Background(), map[string]string{"model": "test"})
+	if err != nil {
+		t.Fatalf("ChatCompletion: %v", err)
+	}
+	if !strings.Contains(string(body), "OK") {
+		t.Errorf("body = %q", body)
+	}
+	if calls != 3 {
+		t.Errorf("made %d requests, want 3", calls)
+	}
+}
+
+func TestChatCompletionDoesNotRetryBadRequests(t *testing.T) {
+	var calls int32
+	server := newStatusServer(t, &calls, http.StatusBadRequest)
+
+	_, err := newTestClient(server).ChatCompletion(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("err = %v, want a rejected request", err)
+	}
+	if calls != 1 {
+		t.Errorf("made %d requests, want 1", calls)
+	}
+}
+
+func TestChatCompletionGivesUpAfterMaxAttempts(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr string
+	}{
+		{http.StatusTooManyRequests, "busy"},
+		{http.StatusBadGateway, ErrUnavailable.Error()},
+	}
+
+	for _, test := range tests {
+		var calls int32
+		server := newStatusServer(t, &calls, test.status)
+
+		_, err := newTestClient(server).ChatCompletion(context.Background(), nil)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("status %d: err = %v, want %q", test.status, err, test.wantErr)
+		}
+		if calls != 3 {
+			t.Errorf("status %d: made %d requests, want 3", test.status, calls)
+		}
+	}
+}
+
+func TestChatCompletionHonoursRetryAfter(t *testing.T) {
+	var calls int32
+	var firstAt, secondAt time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			firstAt = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		secondAt = time.Now()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.MaxBackoff = 5 * time.Second
+	if _, err := client.ChatCompletion(context.Background(), nil); err != nil {
+		t.Fatalf("ChatCompletion: %v", err)
+	}
+	if waited := secondAt.Sub(firstAt); waited < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", waited)
+	}
+}
+
+func TestChatCompletionGivesUpOnLongRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(server).ChatCompletion(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Errorf("err = %v, want the service to be busy", err)
+	}
+	if calls != 1 {
+		t.Errorf("made %d requests, want 1", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := &Client{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	if got := client.backoff(1, 300*time.Millisecond); got != 300*time.Millisecond {
+		t.Errorf("Retry-After: got %v, want %v", got, 300*time.Millisecond)
+	}
+	for retry, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+		if got := client.backoff(retry, 0); got < base || got > base*3/2 {
+			t.Errorf("retry %d: got %v, want %v plus up to 50%% jitter", retry, got, base)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got != 2*time.Second {
+		t.Errorf("seconds: got %v", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got < 58*time.Second || got > time.Minute {
+		t.Errorf("HTTP date: got %v", got)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("%q: got %v, want 0", value, got)
+		}
+	}
+}
+
+func TestChatCompletionTimesOutSlowAttempts(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.CallTimeout = 20 * time.Millisecond
+	_, err := client.ChatCompletion(context.Background(), nil)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want ErrUnavailable", err)
+	}
+	if calls != 3 {
+		t.Errorf("made %d requests, want 3", calls)
+	}
+}
+
+func TestChatCompletionStopsWhenCallerGoesAway(t *testing.T) {
+	var calls int32
+	server := newStatusServer(t, &calls, http.StatusServiceUnavailable)
+
+	client := newTestClient(server)
+	client.BaseBackoff = time.Second
+	client.MaxBackoff = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.ChatCompletion(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context error", err)
+	}
+	if calls != 1 {
+		t.Errorf("made %d requests, want 1", calls)
+	}
+}
+
+func TestChatCompletionOpensBreaker(t *testing.T) {
+	var calls int32
+	server := newStatusServer(t, &calls, http.StatusInternalServerError)
+
+	client := newTestClient(server)
+	client.MaxAttempts = 1
+	client.Breaker = NewCircuitBreaker(2, time.Minute)
+	for i := 0; i < 2; i++ {
+		client.ChatCompletion(context.Background(), nil)
+	}
+
+	if _, err := client.ChatCompletion(context.Background(), nil); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want ErrUnavailable", err)
+	}
+	if calls != 2 {
+		t.Errorf("made %d requests, want 2: the open breaker should fail fast", calls)
+	}
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+
+	authservice "encore.app/backend/auth"
+)
+
+const (
+	minUsernameLength    = 3
+	maxUsernameLength    = 30
+	maxDisplayNameLength = 60
+	maxBioLength         = 500
+	maxProfileLinks      = 5
+
+	// usernameChangeCooldownDays limits how often a profile can change its
+	// username, since every change adds a redirect.
+	usernameChangeCooldownDays = 30
+
+	// defaultUsername is suggested when the sign-in name gives nothing usable.
+	defaultUsername = "cook"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// reservedUsernames cannot be claimed because recipe URLs are
+// /:username/:slug and would collide with the app's own routes.
+var reservedUsernames = map[string]bool{
+	"add-recipe": true,
+	"admin":      true,
+	"api":        true,
+	"home":       true,
+	"login":      true,
+	"logout":     true,
+	"me":         true,
+	"profile":    true,
+	"profiles":   true,
+	"recipes":    true,
+	"settings":   true,
+	"signup":     true,
+	"static":     true,
+	"support":    true,
+}
+
+type PublicProfile struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	Bio         string   `json:"bio"`
+	AvatarUrl   string   `json:"avatar_url"`
+	Links       []string `json:"links"`
+	RecipeCount int      `json:"recipe_count"`
+	// MostCopied lists the profile's recipes that other users copied most often.
+	MostCopied []*RecipeCard `json:"most_copied"`
+}
+
+//encore:api public method=GET path=/api/profiles/:username tag:read
+func GetPublicProfile(ctx context.Context, username string) (*PublicProfile, error) {
+	pro := &PublicProfile{}
+	var profileId string
+
+	err := db.QueryRow(ctx, `
+		SELECT p.id, p.username, p.display_name, p.bio, p.avatar_url, p.links,
+		       (SELECT COUNT(*) FROM recipe r WHERE r.profile_id = p.id AND `+recipeListableCondition+`)
+		FROM profile p
+		WHERE LOWER(p.username) = LOWER($1) AND p.banned_at IS NULL AND NOT p.hidden
+	`, username).Scan(&profileId, &pro.Username, &pro.DisplayName, &pro.Bio, &pro.AvatarUrl, &pro.Links, &pro.RecipeCount)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("profile not found")
+		}
+		return nil, err
+	}
+
+	pro.MostCopied, err = queryRecipeCards(ctx, `
+		SELECT `+recipeCardColumns+`
+		FROM recipe r
+		INNER JOIN profile p ON r.profile_id = p.id
+		INNER JOIN recipe c ON c.copied_from_id = r.id
+		WHERE `+recipeListableCondition+` AND r.profile_id = $1
+		GROUP BY r.id, p.username
+		ORDER BY COUNT(c.id) DESC
+		LIMIT 5
+	`, profileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pro, nil
+}
+
+// validateUsername checks a username against the allowed characters, length
+// and reserved words. The returned error is suitable for showing to the user.
+func validateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username may only contain letters, numbers, hyphens and underscores, and must start with a letter or number")
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return fmt.Errorf("username is reserved")
+	}
+
+	return nil
+}
+
+// validateProfile checks the profile details the client sent. The username
+// is validated separately, since existing usernames are kept as they are.
+func validateProfile(pro *Profile) error {
+	if pro.DisplayName != nil && len(*pro.DisplayName) > maxDisplayNameLength {
+		return fmt.Errorf("display name cannot be longer than %d characters", maxDisplayNameLength)
+	}
+	if pro.Bio != nil && len(*pro.Bio) > maxBioLength {
+		return fmt.Errorf("bio cannot be longer than %d characters", maxBioLength)
+	}
+	if pro.AvatarUrl != nil && *pro.AvatarUrl != "" && !isWebUrl(*pro.AvatarUrl) {
+		return fmt.Errorf("avatar must be an http or https URL")
+	}
+	if len(pro.Links) > maxProfileLinks {
+		return fmt.Errorf("a profile can have at most %d links", maxProfileLinks)
+	}
+	for _, link := range pro.Links {
+		if !isWebUrl(link) {
+			return fmt.Errorf("invalid link: %s", link)
+		}
+	}
+
+	return nil
+}
+
+func isWebUrl(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkUsernameTakenByOther reports whether a profile other than profileId
+// already uses the username, ignoring case.
+func checkUsernameTakenByOther(ctx context.Context, username string, profileId string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM profile
+			WHERE LOWER(username) = LOWER($1) AND id <> $2
+		) OR EXISTS (
+			SELECT 1
+			FROM username_history
+			WHERE LOWER(username) = LOWER($1) AND profile_id <> $2
+		)
+	`, username, profileId).Scan(&taken)
+
+	if err != nil {
+		return false, err
+	}
+
+	return taken, nil
+}
+
+// recordUsernameChange moves the profile's current username into the history
+// when it is being changed, enforcing the change cooldown. Setting the first
+// username, replacing one suggested on first login or changing only its case
+// is not recorded.
+func recordUsernameChange(ctx context.Context, tx *sqldb.Tx, profileId string, newUsername string) error {
+	var oldUsername string
+	var chosen bool
+	err := tx.QueryRow(ctx, `
+		SELECT username, username_chosen
+		FROM profile
+		WHERE id = $1
+		FOR UPDATE
+	`, profileId).Scan(&oldUsername, &chosen)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if oldUsername == "" || !chosen || strings.EqualFold(oldUsername, newUsername) {
+		return nil
+	}
+
+	var recentlyChanged bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM username_history
+			WHERE profile_id = $1 AND changed_at > NOW() - make_interval(days => $2)
+		)
+	`, profileId, usernameChangeCooldownDays).Scan(&recentlyChanged)
+	if err != nil {
+		return err
+	}
+	if recentlyChanged {
+		return fmt.Errorf("username can only be changed once every %d days", usernameChangeCooldownDays)
+	}
+
+	// Switching back to a previous username makes it current again.
+	_, err = tx.Exec(ctx, `
+		DELETE FROM username_history
+		WHERE profile_id = $1 AND LOWER(username) = LOWER($2)
+	`, profileId, newUsername)
+	if err != nil {
+		return fmt.Errorf("error updating username history: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO username_history (username, profile_id)
+		VALUES ($1, $2)
+	`, oldUsername, profileId)
+	if err != nil {
+		return fmt.Errorf("error updating username history: %w", err)
+	}
+
+	return nil
+}
+
+// getUsername returns the profile's current username, or an empty string if
+// the profile does not exist yet.
+func getUsername(ctx context.Context, profileId string) (string, error) {
+	var username string
+	err := db.QueryRow(ctx, `SELECT username FROM profile WHERE id = $1`, profileId).Scan(&username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// resolveOldUsername returns the current username of the profile that used
+// to be called username, or an empty string if no profile did.
+func resolveOldUsername(ctx context.Context, username string) (string, error) {
+	var canonical string
+	err := db.QueryRow(ctx, `
+		SELECT p.username
+		FROM username_history h
+		INNER JOIN profile p ON h.profile_id = p.id
+		WHERE LOWER(h.username) = LOWER($1)
+	`, username).Scan(&canonical)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return canonical, nil
+}
+
+// fillNewProfile prepares a profile that does not exist yet from the caller's
+// sign-in details: a unique username suggested from their name, and their
+// provider display name and photo unless the client supplied its own.
+func fillNewProfile(ctx context.Context, pro *Profile) error {
+	var exists bool
+	err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM profile WHERE id = $1)`, pro.Id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	userData, _ := auth.Data().(*authservice.UserData)
+	if userData == nil {
+		userData = &authservice.UserData{}
+	}
+
+	pro.Username, err = suggestUsername(ctx, userData.Name)
+	if err != nil {
+		return err
+	}
+	if (pro.DisplayName == nil || *pro.DisplayName == "") && len(userData.Name) <= maxDisplayNameLength {
+		pro.DisplayName = &userData.Name
+	}
+	if (pro.AvatarUrl == nil || *pro.AvatarUrl == "") && isWebUrl(userData.Picture) {
+		pro.AvatarUrl = &userData.Picture
+	}
+
+	return nil
+}
+
+// createNewProfile creates the caller's profile from their sign-in details
+// if it does not exist yet. The suggested username is not marked as chosen,
+// so the user can replace it without it being kept in the history. It reports false if another request created a
+// profile with the same ID or username first.
+func createNewProfile(ctx context.Context, profileId string) (bool, error) {
+	pro := &Profile{Id: profileId}
+	if err := fillNewProfile(ctx, pro); err != nil {
+		return false, err
+	}
+	if pro.Username == "" {
+		// The profile already exists.
+		return false, nil
+	}
+
+	result, err := db.Exec(ctx, `
+		INSERT INTO profile (id, username, username_chosen, display_name, avatar_url)
+		VALUES ($1, $2, FALSE, COALESCE($3, ''), COALESCE($4, ''))
+		ON CONFLICT DO NOTHING
+	`, pro.Id, pro.Username, pro.DisplayName, pro.AvatarUrl)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// suggestUsername derives an available username from a display name, adding
+// a numeric suffix if needed. The email is deliberately not used, since
+// usernames are public.
+func suggestUsername(ctx context.Context, name string) (string, error) {
+	base := strings.Trim(usernameInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	// Leave room for a suffix.
+	if len(base) > maxUsernameLength-6 {
+		base = strings.TrimRight(base[:maxUsernameLength-6], "-")
+	}
+	if len(base) < minUsernameLength || reservedUsernames[base] {
+		base = defaultUsername
+	}
+
+	exists, err := checkUsernameExists(ctx, base)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return base, nil
+	}
+
+	// The base only contains [a-z0-9-], so it is safe to use in the pattern.
+	var maxSuffix int
+	err = db.QueryRow(ctx, `
+		WITH existing_usernames AS (
+			SELECT LOWER(username) AS username
+			FROM profile
+			UNION ALL
+			SELECT LOWER(username)
+			FROM username_history
+		)
+		SELECT COALESCE(MAX(CAST(SUBSTRING(username FROM LENGTH($1) + 2) AS INT)), 0)
+		FROM existing_usernames
+		WHERE username ~ ('^' || $1 || '-[0-9]{1,5}$')
+	`, base).Scan(&maxSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%d", base, maxSuffix+1), nil
+}
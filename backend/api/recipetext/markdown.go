@@ -0,0 +1,230 @@
+// Package recipetext renders and parses the Markdown text of recipes.
+// It has no Encore dependencies so it can be tested with plain go test.
+package recipetext
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingPattern     = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	unorderedPattern   = regexp.MustCompile(`^\s*[*\-+•]\s+(.*)$`)
+	orderedPattern     = regexp.MustCompile(`^\s*(\d{1,9})[.)]\s+(.*)$`)
+	codeSpanPattern    = regexp.MustCompile("`([^`]+)`")
+	linkPattern        = regexp.MustCompile(`\[([^\]]*)\]\(\s*((?:[^()\s]|\([^()\s]*\))*)\s*\)`)
+	strongPattern      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	emphasisPattern    = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*|\b_(\S(?:[^_]*?\S)?)_\b`)
+	allowedLinkSchemes = []string{"http://", "https://", "mailto:"}
+)
+
+// markdownBlock is a heading, paragraph or list. Lines holds the paragraph
+// lines or list items.
+type markdownBlock struct {
+	kind  string // "h1" to "h6", "p", "ul" or "ol"
+	start int    // number of the first item of an ordered list
+	lines []string
+}
+
+// RenderMarkdown renders the subset of Markdown recipes use: headings,
+// paragraphs, bulleted and numbered lists, emphasis, code spans and links.
+// The HTML is safe to insert into a page as is: any HTML in the source is
+// escaped and only http, https and mailto links are kept.
+func RenderMarkdown(source string) (htmlText string, plainText string) {
+	blocks := parseMarkdownBlocks(CleanMarkdown(source))
+
+	var htmlParts, textParts []string
+	for _, block := range blocks {
+		htmlParts = append(htmlParts, renderBlockHtml(block))
+		textParts = append(textParts, renderBlockText(block))
+	}
+
+	return strings.Join(htmlParts, "\n"), strings.Join(textParts, "\n\n")
+}
+
+// CleanMarkdown decodes HTML entities, which editors and pasted text leave
+// behind (e.g. "&#x20;"), so they do not leak into the output. Text that was
+// escaped more than once, e.g. "&amp;lt;", is decoded until no entities
+// remain. HTML is kept as text, since "a<b and c>d" is not a tag, and is
+// escaped when rendering. Non-breaking spaces become regular spaces and
+// trailing whitespace is dropped.
+func CleanMarkdown(source string) string {
+	for {
+		decoded := html.UnescapeString(source)
+		if decoded == source {
+			break
+		}
+		source = decoded
+	}
+	source = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\u00a0", " ", "\u200b", "").Replace(source)
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func parseMarkdownBlocks(source string) []*markdownBlock {
+	var blocks []*markdownBlock
+	var current *markdownBlock
+	for _, line := range strings.Split(source, "\n") {
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+
+		if m := headingPattern.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, &markdownBlock{kind: fmt.Sprintf("h%d", len(m[1])), lines: []string{m[2]}})
+			current = nil
+			continue
+		}
+
+		if m := orderedPattern.FindStringSubmatch(line); m != nil {
+			if current == nil || current.kind != "ol" {
+				start, _ := strconv.Atoi(m[1])
+				current = &markdownBlock{kind: "ol", start: start}
+				blocks = append(blocks, current)
+			}
+			current.lines = append(current.lines, strings.TrimSpace(m[2]))
+			continue
+		}
+
+		if m := unorderedPattern.FindStringSubmatch(line); m != nil {
+			if current == nil || current.kind != "ul" {
+				current = &markdownBlock{kind: "ul"}
+				blocks = append(blocks, current)
+			}
+			current.lines = append(current.lines, strings.TrimSpace(m[1]))
+			continue
+		}
+
+		// Indented lines continue the previous list item; anything else
+		// continues a paragraph or starts a new one.
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		if current != nil && (current.kind == "p" || indented) {
+			last := len(current.lines) - 1
+			if current.kind == "p" {
+				current.lines = append(current.lines, strings.TrimSpace(line))
+			} else {
+				current.lines[last] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+
+		current = &markdownBlock{kind: "p", lines: []string{strings.TrimSpace(line)}}
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+func renderBlockHtml(block *markdownBlock) string {
+	var b strings.Builder
+	switch block.kind {
+	case "p":
+		rendered := make([]string, 0, len(block.lines))
+		for _, line := range block.lines {
+			rendered = append(rendered, renderInlineHtml(line))
+		}
+		fmt.Fprintf(&b, "<p>%s</p>", strings.Join(rendered, "<br>\n"))
+	case "ul", "ol":
+		if block.kind == "ol" && block.start != 1 {
+			fmt.Fprintf(&b, `<ol start="%d">`, block.start)
+		} else {
+			fmt.Fprintf(&b, "<%s>", block.kind)
+		}
+		b.WriteString("\n")
+		for _, item := range block.lines {
+			fmt.Fprintf(&b, "<li>%s</li>\n", renderInlineHtml(item))
+		}
+		fmt.Fprintf(&b, "</%s>", block.kind)
+	default:
+		fmt.Fprintf(&b, "<%s>%s</%s>", block.kind, renderInlineHtml(block.lines[0]), block.kind)
+	}
+	return b.String()
+}
+
+func renderBlockText(block *markdownBlock) string {
+	lines := make([]string, 0, len(block.lines))
+	for i, line := range block.lines {
+		text := RenderInlineText(line)
+		switch block.kind {
+		case "ul":
+			text = "• " + text
+		case "ol":
+			text = fmt.Sprintf("%d. %s", block.start+i, text)
+		}
+		lines = append(lines, text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderInlineHtml escapes the text and then renders code spans, links and
+// emphasis. Code spans are rendered first so their contents stay literal.
+func renderInlineHtml(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range codeSpanPattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(renderLinksHtml(text[last:m[0]]))
+		fmt.Fprintf(&b, "<code>%s</code>", html.EscapeString(text[m[2]:m[3]]))
+		last = m[1]
+	}
+	b.WriteString(renderLinksHtml(text[last:]))
+	return b.String()
+}
+
+func renderLinksHtml(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range linkPattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(renderEmphasisHtml(text[last:m[0]]))
+		label := renderEmphasisHtml(text[m[2]:m[3]])
+		if href := text[m[4]:m[5]]; isAllowedLink(href) {
+			fmt.Fprintf(&b, `<a href="%s" rel="nofollow noopener noreferrer">%s</a>`, html.EscapeString(href), label)
+		} else {
+			b.WriteString(label)
+		}
+		last = m[1]
+	}
+	b.WriteString(renderEmphasisHtml(text[last:]))
+	return b.String()
+}
+
+func renderEmphasisHtml(text string) string {
+	text = html.EscapeString(text)
+	text = strongPattern.ReplaceAllString(text, "<strong>$1$2</strong>")
+	return emphasisPattern.ReplaceAllString(text, "<em>$1$2</em>")
+}
+
+// RenderInlineText strips the inline Markdown syntax, keeping link labels
+// and the contents of code spans.
+func RenderInlineText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range codeSpanPattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(stripInlineMarkdown(text[last:m[0]]))
+		b.WriteString(text[m[2]:m[3]])
+		last = m[1]
+	}
+	b.WriteString(stripInlineMarkdown(text[last:]))
+	return b.String()
+}
+
+func stripInlineMarkdown(text string) string {
+	text = linkPattern.ReplaceAllString(text, "$1")
+	text = strongPattern.ReplaceAllString(text, "$1$2")
+	return emphasisPattern.ReplaceAllString(text, "$1$2")
+}
+
+func isAllowedLink(href string) bool {
+	lower := strings.ToLower(href)
+	for _, scheme := range allowedLinkSchemes {
+		if strings.HasPrefix(lower, scheme) && len(lower) > len(scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package recipetext
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		wantHtml string
+		wantText string
+	}{
+		{
+			name:     "drops javascript links",
+			source:   "[x](JavaScript:alert(1))",
+			wantHtml: "<p>x</p>",
+			wantText: "x",
+		},
+		{
+			name:     "keeps http links",
+			source:   "[site](https://example.com/a_(b))",
+			wantHtml: `<p><a href="https://example.com/a_(b)" rel="nofollow noopener noreferrer">site</a></p>`,
+			wantText: "site",
+		},
+		{
+			name:     "escapes quotes in link targets",
+			source:   `[x](https://example.com/"onmouseover="alert(1))`,
+			wantHtml: `<p><a href="https://example.com/&#34;onmouseover=&#34;alert(1)" rel="nofollow noopener noreferrer">x</a></p>`,
+			wantText: "x",
+		},
+		{
+			name:     "escapes html in link labels",
+			source:   "[<img src=x onerror=alert(1)>](https://example.com)",
+			wantHtml: `<p><a href="https://example.com" rel="nofollow noopener noreferrer">&lt;img src=x onerror=alert(1)&gt;</a></p>`,
+			wantText: "<img src=x onerror=alert(1)>",
+		},
+		{
+			name:     "escapes raw html",
+			source:   "<script>alert(1)</script>",
+			wantHtml: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+			wantText: "<script>alert(1)</script>",
+		},
+		{
+			name:     "keeps text that looks like a tag",
+			source:   "a<b and c>d",
+			wantHtml: "<p>a&lt;b and c&gt;d</p>",
+			wantText: "a<b and c>d",
+		},
+		{
+			name:     "keeps html in code spans",
+			source:   "Use `<b>` for bold",
+			wantHtml: "<p>Use <code>&lt;b&gt;</code> for bold</p>",
+			wantText: "Use <b> for bold",
+		},
+		{
+			name:     "decodes entities",
+			source:   "Salt&#x20;&amp; pepper&nbsp;",
+			wantHtml: "<p>Salt &amp; pepper</p>",
+			wantText: "Salt & pepper",
+		},
+		{
+			name:     "decodes entities escaped twice",
+			source:   "&amp;lt;script&amp;gt;",
+			wantHtml: "<p>&lt;script&gt;</p>",
+			wantText: "<script>",
+		},
+		{
+			name:     "renders emphasis",
+			source:   "**bold** and *em* and _em_ and snake_case_name",
+			wantHtml: "<p><strong>bold</strong> and <em>em</em> and <em>em</em> and snake_case_name</p>",
+			wantText: "bold and em and em and snake_case_name",
+		},
+		{
+			name:     "renders headings and lists",
+			source:   "# Title\n\n* a\n* b\n\n3. c\n4. d",
+			wantHtml: "<h1>Title</h1>\n<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol start=\"3\">\n<li>c</li>\n<li>d</li>\n</ol>",
+			wantText: "Title\n\n• a\n• b\n\n3. c\n4. d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotHtml, gotText := RenderMarkdown(tt.source)
+			if gotHtml != tt.wantHtml {
+				t.Errorf("html = %q, want %q", gotHtml, tt.wantHtml)
+			}
+			if gotText != tt.wantText {
+				t.Errorf("text = %q, want %q", gotText, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package recipetext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// unitAbbreviation is the singular and plural spelling of an abbreviation.
+// Abbreviations that are also common words or letters are only expanded
+// after a quantity.
+type unitAbbreviation struct {
+	singular       string
+	plural         string
+	requiresNumber bool
+}
+
+var unitAbbreviations = map[string]unitAbbreviation{
+	"tbsp":   {"tablespoon", "tablespoons", false},
+	"tbsps":  {"tablespoon", "tablespoons", false},
+	"tbs":    {"tablespoon", "tablespoons", false},
+	"tsp":    {"teaspoon", "teaspoons", false},
+	"tsps":   {"teaspoon", "teaspoons", false},
+	"oz":     {"ounce", "ounces", false},
+	"lb":     {"pound", "pounds", false},
+	"lbs":    {"pound", "pounds", false},
+	"kg":     {"kilogram", "kilograms", false},
+	"mg":     {"milligram", "milligrams", false},
+	"ml":     {"milliliter", "milliliters", false},
+	"g":      {"gram", "grams", true},
+	"l":      {"liter", "liters", true},
+	"c":      {"cup", "cups", true},
+	"qt":     {"quart", "quarts", false},
+	"qts":    {"quart", "quarts", false},
+	"pt":     {"pint", "pints", true},
+	"pkg":    {"package", "packages", false},
+	"pkgs":   {"package", "packages", false},
+	"min":    {"minute", "minutes", true},
+	"mins":   {"minute", "minutes", false},
+	"hr":     {"hour", "hours", false},
+	"hrs":    {"hour", "hours", false},
+	"sec":    {"second", "seconds", true},
+	"secs":   {"second", "seconds", false},
+	"approx": {"approximately", "approximately", false},
+	"doz":    {"dozen", "dozen", false},
+}
+
+var (
+	// abbreviationPattern matches an abbreviation, optionally preceded by its quantity.
+	abbreviationPattern = regexp.MustCompile(`(?i)(?:(\d+(?:\.\d+)?(?:\s+\d+/\d+)?|\d+/\d+)\s*|\b)(tbsps?|tbs|tsps?|oz|lbs?|kg|mg|ml|g|l|c|qts?|pt|pkgs?|mins?|hrs?|secs?|approx|doz)\b\.?`)
+	// spokenTemperaturePattern matches temperatures written with a degree
+	// sign, e.g. "350°", "350°f" or "180 °C".
+	spokenTemperaturePattern = regexp.MustCompile(`(\d+)\s*°(?:\s*(?i:([FC])(?:ahrenheit|elsius)?)\b)?`)
+	// spokenFractionPattern matches common fractions, optionally after a
+	// whole number, e.g. "1 1/2" or "1-1/2".
+	spokenFractionPattern = regexp.MustCompile(`(?:(\d+)(?:\s+|-))?\b(1/2|1/3|2/3|1/4|3/4|1/8)\b`)
+	// spokenRangePattern matches numeric ranges such as "2-3". The third
+	// group catches the denominator of a fraction that is not a range.
+	spokenRangePattern = regexp.MustCompile(`(\d+)\s*[-–]\s*(\d+)(/\d+)?`)
+)
+
+var spokenFractions = map[string]string{
+	"1/2": "a half",
+	"1/3": "a third",
+	"2/3": "two thirds",
+	"1/4": "a quarter",
+	"3/4": "three quarters",
+	"1/8": "an eighth",
+}
+
+// ExpandAbbreviations spells out unit abbreviations, using the plural after
+// quantities other than one, e.g. "2 tbsp" becomes "2 tablespoons".
+func ExpandAbbreviations(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range abbreviationPattern.FindAllStringSubmatchIndex(text, -1) {
+		abbreviation, ok := unitAbbreviations[strings.ToLower(text[m[4]:m[5]])]
+		hasNumber := m[2] >= 0
+		// A quantity written straight against a word, e.g. "x2g", is not one.
+		if hasNumber && m[0] > 0 {
+			if r, _ := utf8.DecodeLastRuneInString(text[:m[0]]); unicode.IsLetter(r) {
+				hasNumber = false
+			}
+		}
+		if !ok || (abbreviation.requiresNumber && !hasNumber) {
+			continue
+		}
+
+		word := abbreviation.singular
+		if hasNumber {
+			if quantity, ok := ParseDurationNumber(text[m[2]:m[3]]); ok && quantity > 1 {
+				word = abbreviation.plural
+			}
+		} else if strings.HasSuffix(strings.ToLower(text[m[4]:m[5]]), "s") {
+			word = abbreviation.plural
+		}
+
+		b.WriteString(text[last:m[4]])
+		if hasNumber && m[4] == m[3] {
+			// "250g" reads as "250 grams".
+			b.WriteString(" ")
+		}
+		b.WriteString(word)
+		last = m[1]
+		// Keep a full stop that ended the sentence rather than the abbreviation.
+		if strings.HasSuffix(text[m[0]:m[1]], ".") && (m[1] == len(text) || strings.TrimSpace(text[m[1]:]) == "") {
+			last--
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// SpokenText rewrites symbols that text-to-speech engines read poorly.
+// Fractions are rewritten before ranges so "1-1/2" reads as one and a half.
+func SpokenText(text string) string {
+	text = replaceAllSubmatchFunc(spokenTemperaturePattern, text, func(m []string) string {
+		switch strings.ToUpper(m[2]) {
+		case "F":
+			return m[1] + " degrees Fahrenheit"
+		case "C":
+			return m[1] + " degrees Celsius"
+		}
+		return m[1] + " degrees"
+	})
+
+	text = replaceAllSubmatchFunc(spokenFractionPattern, text, func(m []string) string {
+		if m[1] == "" {
+			return spokenFractions[m[2]]
+		}
+		return m[1] + " and " + spokenFractions[m[2]]
+	})
+
+	return replaceAllSubmatchFunc(spokenRangePattern, text, func(m []string) string {
+		if m[3] != "" {
+			return m[0]
+		}
+		return m[1] + " to " + m[2]
+	})
+}
+
+// replaceAllSubmatchFunc is regexp's ReplaceAllStringFunc with the
+// submatches of each match, found in the context of the whole text.
+func replaceAllSubmatchFunc(pattern *regexp.Regexp, text string, repl func([]string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		groups := make([]string, len(m)/2)
+		for i := range groups {
+			if m[2*i] >= 0 {
+				groups[i] = text[m[2*i]:m[2*i+1]]
+			}
+		}
+		b.WriteString(text[last:m[0]])
+		b.WriteString(repl(groups))
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package recipetext
+
+import "testing"
+
+func TestSpokenText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Bake at 350°F.", "Bake at 350 degrees Fahrenheit."},
+		{"Bake at 350°f until set.", "Bake at 350 degrees Fahrenheit until set."},
+		{"Heat to 180 °C.", "Heat to 180 degrees Celsius."},
+		{"Heat to 180°Celsius", "Heat to 180 degrees Celsius"},
+		{"Preheat to 350°", "Preheat to 350 degrees"},
+		{"Preheat to 350°, then bake", "Preheat to 350 degrees, then bake"},
+		{"Add 1/2 cup", "Add a half cup"},
+		{"Add 1 1/2 cups", "Add 1 and a half cups"},
+		{"Add 1-1/2 cups", "Add 1 and a half cups"},
+		{"Add 11/2 cups", "Add 11/2 cups"},
+		{"Bake 2-3 minutes", "Bake 2 to 3 minutes"},
+		{"Bake 10 – 12 minutes", "Bake 10 to 12 minutes"},
+		{"Add 1-5/8 cups", "Add 1-5/8 cups"},
+		{"Serves 4", "Serves 4"},
+	}
+	for _, tt := range tests {
+		if got := SpokenText(tt.text); got != tt.want {
+			t.Errorf("SpokenText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAbbreviations(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"2 tbsp butter", "2 tablespoons butter"},
+		{"1 tsp salt", "1 teaspoon salt"},
+		{"1/2 tsp salt", "1/2 teaspoon salt"},
+		{"1 1/2 Tbsp oil", "1 1/2 tablespoons oil"},
+		{"250g flour", "250 grams flour"},
+		{"1 c. milk", "1 cup milk"},
+		{"Bake 10 min.", "Bake 10 minutes."},
+		{"approx. 2 lbs", "approximately 2 pounds"},
+		{"oz of cheese", "ounce of cheese"},
+		{"hrs later", "hours later"},
+		{"plan a c", "plan a c"},
+		{"box x2g", "box x2g"},
+		{"Eggs and milk", "Eggs and milk"},
+	}
+	for _, tt := range tests {
+		if got := ExpandAbbreviations(tt.text); got != tt.want {
+			t.Errorf("ExpandAbbreviations(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package recipetext
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"encore.app/backend/api/extraction"
+)
+
+// Step is one instruction, with the timers and temperature detected in its
+// text.
+type Step struct {
+	Position    int
+	Section     string // the heading the step appears under
+	Text        string
+	Timers      []*Timer
+	TempDegF    int // 0 when the step mentions no temperature
+	Ingredients []string
+}
+
+// Timer is a duration mentioned in a step. Ranges such as "8 to 10 hours"
+// have a different minimum and maximum.
+type Timer struct {
+	Label      string
+	MinSeconds int
+	MaxSeconds int
+}
+
+const durationNumber = `(\d+(?:\.\d+)?(?:\s+\d+/\d+)?|\d+/\d+|an?|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|fifteen|twenty|thirty|forty|forty-five|sixty|half an?)`
+
+var (
+	// durationPattern matches a duration or range, e.g. "2 to 3 minutes" or "1 1/2 hrs".
+	durationPattern = regexp.MustCompile(`(?i)\b` + durationNumber + `(?:\s*(?:-|–|to|or)\s*` + durationNumber + `)?\s*(hours?|hrs?|minutes?|mins?|seconds?|secs?)\b`)
+	// durationJoiner matches the text between the parts of "1 hour and 30 minutes".
+	durationJoiner = regexp.MustCompile(`(?i)^\s*(?:and\s*)?$`)
+	// temperaturePattern matches temperatures such as "350°F", "180 °C", "400
+	// degrees" or "425 F". A bare C must follow the number directly, as in
+	// "200C", since "12 c" is more likely cups.
+	temperaturePattern = regexp.MustCompile(`(?i)\b(\d{2,3})(?:\s*°\s*([FC])?|\s*degrees?\s*(f\b|c\b|fahrenheit|celsius)?|\s*(F)\b|(C)\b)`)
+	// ingredientQuantity matches the quantity and unit at the start of an ingredient line.
+	ingredientQuantity = regexp.MustCompile(`(?i)^[\d\s./½⅓⅔¼¾⅛-]*(?:(?:cups?|c\.|tablespoons?|tbsps?|tsps?|teaspoons?|ounces?|oz|pounds?|lbs?|grams?|g|kg|ml|l|liters?|litres?|quarts?|pints?|cloves?|cans?|packages?|pkgs?|sticks?|pinch(?:es)?|dash(?:es)?|slices?|large|medium|small|whole)\.?\s+)*(?:of\s+)?`)
+)
+
+var durationWords = map[string]float64{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "fifteen": 15,
+	"twenty": 20, "thirty": 30, "forty": 40, "forty-five": 45, "sixty": 60, "half a": 0.5, "half an": 0.5,
+}
+
+// ParseSteps splits Markdown instructions into steps. List items become
+// steps, and a line right below a list item, or indented under it,
+// continues that step. Markdown headings and lines ending with a colon are
+// taken as section headings. Any other line starts a step of its own, so
+// instructions written as plain lines or paragraphs still have steps.
+func ParseSteps(instructions string, ingredients string) []*Step {
+	instructions = CleanMarkdown(instructions)
+	ingredientLines := parseIngredientLines(CleanMarkdown(ingredients))
+
+	lines := strings.Split(instructions, "\n")
+	hasList := false
+	for _, line := range lines {
+		if _, ok := extraction.ListItemText(line); ok {
+			hasList = true
+			break
+		}
+	}
+
+	steps := []*Step{}
+	section := ""
+	var current *Step
+	afterBlank := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			afterBlank = true
+			continue
+		}
+		newParagraph := afterBlank
+		afterBlank = false
+
+		if item, ok := extraction.ListItemText(line); ok {
+			current = &Step{Position: len(steps) + 1, Section: section, Text: item}
+			steps = append(steps, current)
+			continue
+		}
+		if isStepHeading(trimmed) {
+			section = strings.TrimSuffix(strings.Trim(strings.TrimLeft(trimmed, "# "), "*_ "), ":")
+			current = nil
+			continue
+		}
+		indented := strings.TrimLeft(line, " \t") != line
+		if hasList && current != nil && (!newParagraph || indented) {
+			current.Text += " " + trimmed
+			continue
+		}
+
+		current = &Step{Position: len(steps) + 1, Section: section, Text: trimmed}
+		steps = append(steps, current)
+	}
+
+	for _, step := range steps {
+		plain := RenderInlineText(step.Text)
+		step.Timers = findStepTimers(plain)
+		step.TempDegF = findStepTemperature(plain)
+		step.Ingredients = findStepIngredients(plain, ingredientLines)
+	}
+
+	return steps
+}
+
+func findStepTimers(text string) []*Timer {
+	timers := []*Timer{}
+	matches := durationPattern.FindAllStringSubmatchIndex(text, -1)
+	for i := 0; i < len(matches); i++ {
+		m := matches[i]
+		minSeconds, maxSeconds, ok := parseDurationMatch(text, m)
+		if !ok {
+			continue
+		}
+		end := m[1]
+
+		// Combine "1 hour and 30 minutes" into one timer.
+		if i+1 < len(matches) && durationJoiner.MatchString(text[end:matches[i+1][0]]) {
+			if nextMin, nextMax, ok := parseDurationMatch(text, matches[i+1]); ok {
+				minSeconds += nextMin
+				maxSeconds += nextMax
+				end = matches[i+1][1]
+				i++
+			}
+		}
+
+		timers = append(timers, &Timer{Label: text[m[0]:end], MinSeconds: minSeconds, MaxSeconds: maxSeconds})
+	}
+	return timers
+}
+
+// isStepHeading reports whether a line that is not a list item is a
+// section heading, e.g. "## For the sauce" or "**For the sauce:**".
+func isStepHeading(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasSuffix(strings.Trim(line, "*_ "), ":")
+}
+
+// parseDurationMatch converts a durationPattern match into seconds.
+func parseDurationMatch(text string, m []int) (int, int, bool) {
+	from, ok := ParseDurationNumber(text[m[2]:m[3]])
+	if !ok {
+		return 0, 0, false
+	}
+	to := from
+	if m[4] >= 0 {
+		if to, ok = ParseDurationNumber(text[m[4]:m[5]]); !ok || to < from {
+			return 0, 0, false
+		}
+	}
+
+	unit := strings.ToLower(text[m[6]:m[7]])
+	seconds := 1.0
+	switch {
+	case strings.HasPrefix(unit, "h"):
+		seconds = 3600
+	case strings.HasPrefix(unit, "m"):
+		seconds = 60
+	}
+
+	return int(math.Round(from * seconds)), int(math.Round(to * seconds)), true
+}
+
+// ParseDurationNumber parses a quantity such as "2", "1 1/2", "3/4" or
+// "half an".
+func ParseDurationNumber(s string) (float64, bool) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if v, ok := durationWords[s]; ok {
+		return v, true
+	}
+
+	total := 0.0
+	for _, part := range strings.Fields(s) {
+		if num, den, found := strings.Cut(part, "/"); found {
+			n, err1 := strconv.ParseFloat(num, 64)
+			d, err2 := strconv.ParseFloat(den, 64)
+			if err1 != nil || err2 != nil || d == 0 {
+				return 0, false
+			}
+			total += n / d
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		total += v
+	}
+	return total, total > 0
+}
+
+// findStepTemperature returns the first temperature in the step in °F.
+// Temperatures without a unit are taken as Fahrenheit.
+func findStepTemperature(text string) int {
+	m := temperaturePattern.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	value, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+
+	unit := strings.ToLower(m[2] + m[3] + m[4] + m[5])
+	if strings.HasPrefix(unit, "c") {
+		value = int(math.Round(float64(value)*9/5 + 32))
+	}
+	if value > extraction.MaxCookTempDegF {
+		return 0
+	}
+	return value
+}
+
+// ingredientLine is an ingredient list item with the name used to find it
+// in step text, and the last word of a longer name as a fallback.
+type ingredientLine struct {
+	text     string
+	name     string
+	lastWord string
+}
+
+// IngredientItems returns the text of each item in the ingredient list.
+func IngredientItems(ingredients string) []string {
+	var items []string
+	for _, line := range parseIngredientLines(ingredients) {
+		items = append(items, line.text)
+	}
+	return items
+}
+
+// parseIngredientLines extracts the ingredient items with their names, i.e.
+// the text without the quantity, unit or anything after a comma.
+func parseIngredientLines(ingredients string) []ingredientLine {
+	var lines []ingredientLine
+	for _, line := range strings.Split(ingredients, "\n") {
+		text, ok := extraction.ListItemText(line)
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(ingredientQuantity.ReplaceAllString(text, ""))
+		if i := strings.IndexAny(name, ",(;"); i >= 0 {
+			name = name[:i]
+		}
+		name = strings.Trim(strings.NewReplacer("**", "", "__", "").Replace(name), " .*")
+		if name == "" {
+			continue
+		}
+
+		ingredient := ingredientLine{text: text, name: name}
+		if words := strings.Fields(name); len(words) > 1 && len(words[len(words)-1]) > 3 {
+			ingredient.lastWord = words[len(words)-1]
+		}
+		lines = append(lines, ingredient)
+	}
+	return lines
+}
+
+// findStepIngredients matches ingredients by their full name, or failing
+// that by the last word of the name, e.g. "flour" for "all-purpose flour".
+func findStepIngredients(text string, ingredients []ingredientLine) []string {
+	lower := strings.ToLower(text)
+	found := []string{}
+	for _, ingredient := range ingredients {
+		if containsWord(lower, ingredient.name) || (ingredient.lastWord != "" && containsWord(lower, ingredient.lastWord)) {
+			found = append(found, ingredient.text)
+		}
+	}
+	return found
+}
+
+// containsWord reports whether text contains word, or its plural ending in
+// "s" or "es", with word boundaries on both sides as regexp's \b has them.
+func containsWord(text string, word string) bool {
+	if word == "" {
+		return false
+	}
+	for offset := 0; offset < len(text); {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(word)
+		if isWordBoundary(text, start) {
+			for _, suffix := range []string{"", "s", "es"} {
+				if strings.HasPrefix(text[end:], suffix) && isWordBoundary(text, end+len(suffix)) {
+					return true
+				}
+			}
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+// isWordBoundary reports whether there is an ASCII word character on one
+// side of position i and not on the other.
+func isWordBoundary(text string, i int) bool {
+	before := i > 0 && isWordByte(text[i-1])
+	after := i < len(text) && isWordByte(text[i])
+	return before != after
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
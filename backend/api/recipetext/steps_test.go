@@ -0,0 +1,181 @@
+package recipetext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindStepTimers(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Timer
+	}{
+		{"Bake for 25 minutes.", []Timer{{"25 minutes", 1500, 1500}}},
+		{"Simmer 8 to 10 hours.", []Timer{{"8 to 10 hours", 28800, 36000}}},
+		{"Rest 1 hour and 30 minutes, then slice.", []Timer{{"1 hour and 30 minutes", 5400, 5400}}},
+		{"Cook 1 1/2 hrs or until tender.", []Timer{{"1 1/2 hrs", 5400, 5400}}},
+		{"Whisk for half a minute.", []Timer{{"half a minute", 30, 30}}},
+		{"Fry 2-3 mins, then rest 5 minutes.", []Timer{{"2-3 mins", 120, 180}, {"5 minutes", 300, 300}}},
+		{"Bake 10 to 5 minutes.", nil},
+		{"Add 2 cups of flour.", nil},
+	}
+	for _, tt := range tests {
+		var got []Timer
+		for _, timer := range findStepTimers(tt.text) {
+			got = append(got, *timer)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findStepTimers(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseDurationNumber(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   float64
+		wantOk bool
+	}{
+		{"2", 2, true},
+		{"1.5", 1.5, true},
+		{"1 1/2", 1.5, true},
+		{"3/4", 0.75, true},
+		{"Half  an", 0.5, true},
+		{"forty-five", 45, true},
+		{"1/0", 0, false},
+		{"0", 0, false},
+		{"some", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDurationNumber(tt.s)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("ParseDurationNumber(%q) = %v, %v, want %v, %v", tt.s, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestFindStepTemperature(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"Preheat the oven to 350°F.", 350},
+		{"Heat to 180 °C.", 356},
+		{"Bake at 400 degrees until golden.", 400},
+		{"Roast at 200C, then 425°F.", 392},
+		{"Heat the oil to 375 degrees Fahrenheit.", 375},
+		{"Heat to 100 degrees celsius.", 212},
+		{"Bake at 900°C.", 0},
+		{"Bake at 350 F until set.", 350},
+		{"Roast at 200c.", 392},
+		{"Stir in 12 c water.", 0},
+		{"Add 2 eggs.", 0},
+	}
+	for _, tt := range tests {
+		if got := findStepTemperature(tt.text); got != tt.want {
+			t.Errorf("findStepTemperature(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFindStepIngredients(t *testing.T) {
+	ingredients := parseIngredientLines("* 2 cups all-purpose flour\n* 3 large eggs, beaten\n* 1 tbsp **butter**\n* 1 lemon\n* Salt")
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Whisk the eggs with the flour.", []string{"2 cups all-purpose flour", "3 large eggs, beaten"}},
+		{"Melt the butter.", []string{"1 tbsp **butter**"}},
+		{"Add the lemons and a pinch of salt.", []string{"1 lemon", "Salt"}},
+		{"Add the buttermilk and lemonade.", []string{}},
+		{"Stir in the salted_nuts.", []string{}},
+	}
+	for _, tt := range tests {
+		if got := findStepIngredients(tt.text, ingredients); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findStepIngredients(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContainsWord(t *testing.T) {
+	tests := []struct {
+		text string
+		word string
+		want bool
+	}{
+		{"add the egg", "egg", true},
+		{"add the eggs", "egg", true},
+		{"add the tomatoes", "tomato", true},
+		{"add the eggplant", "egg", false},
+		{"add the nutmeg", "egg", false},
+		{"eggnog, then egg", "egg", true},
+		{"add olive oil.", "olive oil", true},
+		{"add oil", "", false},
+	}
+	for _, tt := range tests {
+		if got := containsWord(tt.text, tt.word); got != tt.want {
+			t.Errorf("containsWord(%q, %q) = %v, want %v", tt.text, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	steps := ParseSteps("## For the sauce\n1. Melt the **butter** at 350°F.\n   Stir 2 minutes.\n2. Add salt.",
+		"* 2 tbsp butter\n* salt")
+	if len(steps) != 2 {
+		t.Fatalf("got %d steps, want 2", len(steps))
+	}
+
+	first := steps[0]
+	if first.Position != 1 || first.Section != "For the sauce" || first.Text != "Melt the **butter** at 350°F. Stir 2 minutes." {
+		t.Errorf("first step = %+v", first)
+	}
+	if first.TempDegF != 350 || len(first.Timers) != 1 || first.Timers[0].MinSeconds != 120 {
+		t.Errorf("first step temperature %d, timers %+v", first.TempDegF, first.Timers)
+	}
+	if !reflect.DeepEqual(first.Ingredients, []string{"2 tbsp butter"}) {
+		t.Errorf("first step ingredients = %q", first.Ingredients)
+	}
+	if steps[1].Position != 2 || !reflect.DeepEqual(steps[1].Ingredients, []string{"salt"}) {
+		t.Errorf("second step = %+v", steps[1])
+	}
+}
+
+func TestParseStepsWithoutList(t *testing.T) {
+	steps := ParseSteps("Preheat the oven to 350°F.\nMix the flour and butter.\n\nFor the topping:\nBake for 25 minutes.",
+		"* 2 cups flour\n* 1 stick butter")
+
+	want := []struct {
+		section string
+		text    string
+	}{
+		{"", "Preheat the oven to 350°F."},
+		{"", "Mix the flour and butter."},
+		{"For the topping", "Bake for 25 minutes."},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(want))
+	}
+	for i, step := range steps {
+		if step.Position != i+1 || step.Section != want[i].section || step.Text != want[i].text {
+			t.Errorf("step %d = %+v, want %+v", i+1, step, want[i])
+		}
+	}
+	if steps[0].TempDegF != 350 || len(steps[1].Ingredients) != 2 || len(steps[2].Timers) != 1 {
+		t.Errorf("steps were not analysed: %+v %+v %+v", steps[0], steps[1], steps[2])
+	}
+}
+
+func TestParseStepsContinuationLines(t *testing.T) {
+	steps := ParseSteps("Make the dough.\n\n1. Mix.\nThen bake.\n\n    Until golden.\n2. Cool.\n\nServe warm.", "")
+
+	want := []string{"Make the dough.", "Mix. Then bake. Until golden.", "Cool.", "Serve warm."}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(steps), len(want), steps)
+	}
+	for i, step := range steps {
+		if step.Text != want[i] || step.Section != "" {
+			t.Errorf("step %d = %+v, want text %q", i+1, step, want[i])
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"encore.dev/beta/auth"
+	"encore.dev/rlog"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+)
+
+const (
+	// autoHideReportThreshold is the number of distinct users with an open
+	// report on the same content that hides it until an admin reviews it.
+	// Only reporters whose accounts are established count towards it.
+	autoHideReportThreshold = 3
+	// establishedAccountDays is how old a profile must be for its reports
+	// to count towards autoHideReportThreshold.
+	establishedAccountDays = 7
+	maxReportsPerDay       = 20
+	maxReportDetailsLength = 1000
+
+	// systemModeratorId is recorded in the moderation log for automatic actions.
+	systemModeratorId = "system"
+)
+
+var reportReasons = map[string]bool{
+	"spam":      true,
+	"copyright": true,
+	"offensive": true,
+	"other":     true,
+}
+
+type CreateReportRequest struct {
+	// TargetType is "recipe" or "profile".
+	TargetType string `json:"target_type"`
+	// TargetId is the recipe ID for recipes, or the username for profiles.
+	TargetId string `json:"target_id"`
+	// Reason is one of "spam", "copyright", "offensive" or "other".
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type Report struct {
+	Id               string     `json:"id"`
+	ReporterUsername string     `json:"reporter_username"`
+	TargetType       string     `json:"target_type"`
+	TargetId         string     `json:"target_id"`
+	Reason           string     `json:"reason"`
+	Details          string     `json:"details"`
+	Status           string     `json:"status"`
+	ResolutionNote   string     `json:"resolution_note"`
+	ResolvedAt       *time.Time `json:"resolved_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	// OpenReportCount is the number of open reports on the same target.
+	OpenReportCount int `json:"open_report_count"`
+	// TargetHidden is whether the reported content is currently hidden.
+	TargetHidden bool `json:"target_hidden"`
+}
+
+type ReportListParams struct {
+	// Status filters reports by status, defaulting to "open".
+	Status string `query:"status"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+type ReportListResponse struct {
+	Reports []*Report `json:"reports"`
+}
+
+type ResolveReportRequest struct {
+	// Status is "resolved" or "dismissed".
+	Status string `json:"status"`
+	Note   string `json:"note"`
+	// Action optionally changes the reported content: "hide" hides it and
+	// "restore" makes content hidden automatically after reports visible
+	// again. Content an admin hid cannot be restored this way.
+	Action string `json:"action"`
+}
+
+//encore:api auth method=POST path=/api/reports
+func CreateReport(ctx context.Context, req *CreateReportRequest) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	if !reportReasons[req.Reason] {
+		return fmt.Errorf("invalid report reason: %s", req.Reason)
+	}
+	if utf8.RuneCountInString(req.Details) > maxReportDetailsLength {
+		return fmt.Errorf("details cannot be longer than %d characters", maxReportDetailsLength)
+	}
+
+	targetId, ownerId, err := resolveReportTarget(ctx, req.TargetType, req.TargetId)
+	if err != nil {
+		return err
+	}
+	if ownerId == string(authResult) {
+		return fmt.Errorf("cannot report your own content")
+	}
+
+	reportId, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating report ID: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the reporter's reports for the rest of the transaction, so
+	// concurrent requests cannot all pass the daily limit before any of
+	// them inserts.
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('report:' || $1))`, string(authResult))
+	if err != nil {
+		return err
+	}
+
+	var reportsToday int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM content_report
+		WHERE reporter_id = $1 AND created_at > NOW() - INTERVAL '1 day'
+	`, string(authResult)).Scan(&reportsToday)
+	if err != nil {
+		return err
+	}
+	if reportsToday >= maxReportsPerDay {
+		return fmt.Errorf("too many reports, please try again later")
+	}
+
+	// Reporting the same content twice while the first report is open is a no-op.
+	_, err = tx.Exec(ctx, `
+		INSERT INTO content_report (id, reporter_id, target_type, target_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (reporter_id, target_type, target_id) WHERE status = 'open' DO NOTHING
+	`, reportId.String(), string(authResult), req.TargetType, targetId, req.Reason, req.Details)
+	if err != nil {
+		return fmt.Errorf("error saving report: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return autoHideReportedContent(ctx, req.TargetType, targetId)
+}
+
+//encore:api auth method=GET path=/api/admin/reports tag:read
+func AdminListReports(ctx context.Context, params *ReportListParams) (*ReportListResponse, error) {
+	if _, err := requireAdmin(); err != nil {
+		return nil, err
+	}
+
+	status := params.Status
+	if status == "" {
+		status = "open"
+	}
+
+	limit, offset := adminPage(params.Limit, params.Offset)
+	rows, err := db.Query(ctx, `
+		SELECT c.id, p.username, c.target_type, c.target_id, c.reason, c.details, c.status,
+		       c.resolution_note, c.resolved_at, c.created_at,
+		       (SELECT COUNT(*) FROM content_report o
+		        WHERE o.target_type = c.target_type AND o.target_id = c.target_id AND o.status = 'open'),
+		       CASE c.target_type
+		           WHEN 'recipe' THEN COALESCE((SELECT hidden FROM recipe WHERE id = c.target_id), FALSE)
+		           ELSE COALESCE((SELECT hidden FROM profile WHERE id = c.target_id), FALSE)
+		       END
+		FROM content_report c
+		INNER JOIN profile p ON c.reporter_id = p.id
+		WHERE c.status = $1
+		ORDER BY 11 DESC, c.created_at
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		r := &Report{}
+		if err := rows.Scan(&r.Id, &r.ReporterUsername, &r.TargetType, &r.TargetId, &r.Reason, &r.Details, &r.Status,
+			&r.ResolutionNote, &r.ResolvedAt, &r.CreatedAt, &r.OpenReportCount, &r.TargetHidden); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &ReportListResponse{Reports: reports}, nil
+}
+
+// AdminResolveReport closes a report, along with every other open report on
+// the same content, and optionally hides or restores that content.
+//
+//encore:api auth method=POST path=/api/admin/reports/:id/resolve
+func AdminResolveReport(ctx context.Context, id string, req *ResolveReportRequest) error {
+	adminId, err := requireAdmin()
+	if err != nil {
+		return err
+	}
+
+	if req.Status != "resolved" && req.Status != "dismissed" {
+		return fmt.Errorf("invalid status: %s", req.Status)
+	}
+	if req.Action != "" && req.Action != "hide" && req.Action != "restore" {
+		return fmt.Errorf("invalid action: %s", req.Action)
+	}
+
+	var targetType, targetId string
+	err = db.QueryRow(ctx, `SELECT target_type, target_id FROM content_report WHERE id = $1`, id).Scan(&targetType, &targetId)
+	if err != nil {
+		return fmt.Errorf("report not found")
+	}
+
+	// Change the content first, so the reports stay open if it fails.
+	switch req.Action {
+	case "hide":
+		if _, err := setContentHidden(ctx, targetType, targetId, true, false); err != nil {
+			return err
+		}
+	case "restore":
+		changed, err := setContentHidden(ctx, targetType, targetId, false, true)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return fmt.Errorf("only content hidden automatically after reports can be restored")
+		}
+	}
+
+	_, err = db.Exec(ctx, `
+		UPDATE content_report
+		SET status = $3, resolution_note = $4, resolved_by = $5, resolved_at = NOW()
+		WHERE target_type = $1 AND target_id = $2 AND (status = 'open' OR id = $6)
+	`, targetType, targetId, req.Status, req.Note, adminId, id)
+	if err != nil {
+		return fmt.Errorf("error resolving report: %w", err)
+	}
+
+	if req.Action == "" {
+		return nil
+	}
+
+	return logModerationAction(ctx, adminId, req.Action+"_"+targetType, targetType, targetId, req.Note)
+}
+
+// resolveReportTarget validates a report target and returns its stored ID
+// along with the profile ID that owns it.
+func resolveReportTarget(ctx context.Context, targetType string, targetId string) (string, string, error) {
+	switch targetType {
+	case "recipe":
+		ownerId, err := getRecipeProfileId(ctx, targetId)
+		if err != nil {
+			return "", "", err
+		}
+		return targetId, ownerId, nil
+	case "profile":
+		profileId, err := getProfileIdByUsername(ctx, strings.TrimSpace(targetId))
+		if err != nil {
+			return "", "", err
+		}
+		return profileId, profileId, nil
+	}
+
+	return "", "", fmt.Errorf("invalid report target type: %s", targetType)
+}
+
+// autoHideReportedContent hides content once enough distinct users have open
+// reports on it. An admin can restore it when resolving the reports. Reports
+// from accounts that are new, banned or hidden themselves are left for an
+// admin, so a handful of throwaway accounts cannot hide anything.
+func autoHideReportedContent(ctx context.Context, targetType string, targetId string) error {
+	var reporters int
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT c.reporter_id)
+		FROM content_report c
+		INNER JOIN profile p ON c.reporter_id = p.id
+		WHERE c.target_type = $1 AND c.target_id = $2 AND c.status = 'open'
+		  AND p.banned_at IS NULL AND NOT p.hidden
+		  AND (p.created_at IS NULL OR p.created_at < NOW() - make_interval(days => $3))
+	`, targetType, targetId, establishedAccountDays).Scan(&reporters)
+	if err != nil {
+		return err
+	}
+	if reporters < autoHideReportThreshold {
+		return nil
+	}
+
+	changed, err := setContentHidden(ctx, targetType, targetId, true, true)
+	if err != nil || !changed {
+		return err
+	}
+	rlog.Info("auto-hid reported content", "target_type", targetType, "target_id", targetId, "reporters", reporters)
+
+	reason := fmt.Sprintf("%d open reports", reporters)
+	return logModerationAction(ctx, systemModeratorId, "auto_hide_"+targetType, targetType, targetId, reason)
+}
+
+// setContentHidden hides or restores a recipe or profile, reporting whether
+// anything changed. byReports marks a hide as automatic; a hide by an admin
+// replaces it, but an automatic hide never replaces one by an admin. With
+// byReports, restoring only undoes automatic hides.
+func setContentHidden(ctx context.Context, targetType string, targetId string, hidden bool, byReports bool) (bool, error) {
+	var table string
+	switch targetType {
+	case "recipe":
+		table = "recipe"
+	case "profile":
+		table = "profile"
+	default:
+		return false, fmt.Errorf("invalid target type: %s", targetType)
+	}
+
+	var result sqldb.ExecResult
+	var err error
+	if hidden {
+		result, err = db.Exec(ctx, `
+			UPDATE `+table+`
+			SET hidden = TRUE, hidden_by_reports = $2
+			WHERE id = $1 AND (NOT hidden OR (hidden_by_reports AND NOT $2))
+		`, targetId, byReports)
+	} else {
+		result, err = db.Exec(ctx, `
+			UPDATE `+table+`
+			SET hidden = FALSE, hidden_by_reports = FALSE
+			WHERE id = $1 AND hidden AND (hidden_by_reports OR NOT $2)
+		`, targetId, byReports)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating %s: %w", targetType, err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
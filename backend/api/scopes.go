@@ -0,0 +1,31 @@
+package api
+
+import (
+	"fmt"
+
+	"encore.dev/beta/auth"
+	"encore.dev/middleware"
+
+	authservice "encore.app/backend/auth"
+)
+
+// readScopeTag marks endpoints that only read data and can be called with a
+// read-scoped API token. Every other endpoint needs a write-scoped token.
+const readScopeTag = "read"
+
+// enforceTokenScope rejects read-scoped API tokens on endpoints that are not
+// tagged as read-only.
+//
+//encore:middleware target=all
+func enforceTokenScope(req middleware.Request, next middleware.Next) middleware.Response {
+	userData, _ := auth.Data().(*authservice.UserData)
+	if userData == nil || userData.TokenScope != authservice.TokenScopeRead {
+		return next(req)
+	}
+
+	if api := req.Data().API; api != nil && api.Tags.Has(readScopeTag) {
+		return next(req)
+	}
+
+	return middleware.Response{Err: fmt.Errorf("this API token only has read access")}
+}
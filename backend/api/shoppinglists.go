@@ -0,0 +1,492 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+
+	"encore.app/backend/api/recipetext"
+)
+
+const (
+	maxShoppingListsPerOwner  = 50
+	maxShoppingListItems      = 500
+	maxShoppingListNameLength = 100
+	maxShoppingListItemLength = 200
+)
+
+var errShoppingListNotFound = errors.New("shopping list not found")
+
+// ShoppingList is a list of things to buy. Lists with a household ID are
+// shared with the household.
+type ShoppingList struct {
+	Id          string              `json:"id"`
+	HouseholdId *string             `json:"household_id"`
+	Name        string              `json:"name"`
+	Items       []*ShoppingListItem `json:"items"`
+}
+
+type ShoppingListItem struct {
+	Id      string `json:"id"`
+	Text    string `json:"text"`
+	Checked bool   `json:"checked"`
+	// RecipeId is set on items added from a recipe's ingredients.
+	RecipeId *string `json:"recipe_id"`
+}
+
+type ShoppingListParams struct {
+	// Household lists the household's shopping lists instead of the
+	// caller's own.
+	Household string `query:"household"`
+}
+
+type ShoppingListsResponse struct {
+	Lists []*ShoppingList `json:"lists"`
+}
+
+type SaveShoppingListRequest struct {
+	Name string `json:"name"`
+	// HouseholdId shares a new list with the household. It is ignored when
+	// renaming a list.
+	HouseholdId *string `json:"household_id"`
+}
+
+type AddShoppingListItemRequest struct {
+	Text string `json:"text"`
+}
+
+// UpdateShoppingListItemRequest changes the fields that are set.
+type UpdateShoppingListItemRequest struct {
+	Text    *string `json:"text"`
+	Checked *bool   `json:"checked"`
+}
+
+// GetShoppingLists returns the caller's own shopping lists, or a
+// household's, with their items.
+//
+//encore:api auth method=GET path=/api/shopping-lists tag:read
+func GetShoppingLists(ctx context.Context, params *ShoppingListParams) (*ShoppingListsResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := checkHouseholdScope(ctx, params.Household); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, household_id, name
+		FROM shopping_list
+		WHERE CASE WHEN $1 = '' THEN profile_id = $2 ELSE household_id = $1 END
+		ORDER BY created_at
+	`, params.Household, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*ShoppingList{}
+	for rows.Next() {
+		list := &ShoppingList{Items: []*ShoppingListItem{}}
+		if err := rows.Scan(&list.Id, &list.HouseholdId, &list.Name); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	if err := loadShoppingListItems(ctx, lists); err != nil {
+		return nil, err
+	}
+
+	return &ShoppingListsResponse{Lists: lists}, nil
+}
+
+//encore:api auth method=GET path=/api/shopping-lists/:id tag:read
+func GetShoppingList(ctx context.Context, id string) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleViewer); err != nil {
+		return nil, err
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+// CreateShoppingList creates an empty shopping list, shared with a household
+// when a household ID is given. Household lists can be changed by editors
+// and owners.
+//
+//encore:api auth method=POST path=/api/shopping-lists
+func CreateShoppingList(ctx context.Context, req *SaveShoppingListRequest) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if req.HouseholdId != nil {
+		if err := requireHouseholdRole(ctx, *req.HouseholdId, string(authResult), HouseholdRoleEditor); err != nil {
+			return nil, err
+		}
+	}
+	if utf8.RuneCountInString(req.Name) > maxShoppingListNameLength {
+		return nil, fmt.Errorf("name must be at most %d characters", maxShoppingListNameLength)
+	}
+
+	var ownerId *string
+	if req.HouseholdId == nil {
+		profileId := string(authResult)
+		ownerId = &profileId
+	}
+
+	var listCount int
+	err := db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM shopping_list
+		WHERE profile_id = $1 OR household_id = $2
+	`, ownerId, req.HouseholdId).Scan(&listCount)
+	if err != nil {
+		return nil, err
+	}
+	if listCount >= maxShoppingListsPerOwner {
+		return nil, fmt.Errorf("too many shopping lists, please delete some first")
+	}
+
+	listId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating shopping list ID: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO shopping_list (id, profile_id, household_id, name)
+		VALUES ($1, $2, $3, $4)
+	`, listId.String(), ownerId, req.HouseholdId, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error creating shopping list: %w", err)
+	}
+
+	return getShoppingList(ctx, listId.String())
+}
+
+//encore:api auth method=POST path=/api/shopping-lists/:id
+func RenameShoppingList(ctx context.Context, id string, req *SaveShoppingListRequest) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(req.Name) > maxShoppingListNameLength {
+		return nil, fmt.Errorf("name must be at most %d characters", maxShoppingListNameLength)
+	}
+
+	_, err := db.Exec(ctx, `UPDATE shopping_list SET name = $2 WHERE id = $1`, id, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error renaming shopping list: %w", err)
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+//encore:api auth method=DELETE path=/api/shopping-lists/:id
+func DeleteShoppingList(ctx context.Context, id string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(ctx, `DELETE FROM shopping_list WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting shopping list: %w", err)
+	}
+
+	return nil
+}
+
+//encore:api auth method=POST path=/api/shopping-lists/:id/items
+func AddShoppingListItem(ctx context.Context, id string, req *AddShoppingListItemRequest) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+
+	if err := addShoppingListItems(ctx, id, []string{req.Text}, nil); err != nil {
+		return nil, err
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+// AddRecipeToShoppingList adds each of the recipe's ingredients to the list
+// as plain text.
+//
+//encore:api auth method=POST path=/api/shopping-lists/:id/recipes/:recipeId
+func AddRecipeToShoppingList(ctx context.Context, id string, recipeId string) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+	if err := checkRecipeVisible(ctx, recipeId, string(authResult)); err != nil {
+		return nil, err
+	}
+
+	var ingredients string
+	err := db.QueryRow(ctx, `SELECT ingredients FROM recipe WHERE id = $1`, recipeId).Scan(&ingredients)
+	if err != nil {
+		return nil, err
+	}
+
+	var texts []string
+	for _, item := range recipetext.IngredientItems(ingredients) {
+		if text := strings.Join(strings.Fields(renderInlineText(cleanMarkdown(item))), " "); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("recipe has no ingredients")
+	}
+
+	if err := addShoppingListItems(ctx, id, texts, &recipeId); err != nil {
+		return nil, err
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+//encore:api auth method=POST path=/api/shopping-lists/:id/items/:itemId
+func UpdateShoppingListItem(ctx context.Context, id string, itemId string, req *UpdateShoppingListItemRequest) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+	if req.Text != nil {
+		if err := validateShoppingListItem(*req.Text); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE shopping_list_item
+		SET text = COALESCE($3::TEXT, text), checked = COALESCE($4::BOOLEAN, checked)
+		WHERE id = $2 AND shopping_list_id = $1
+	`, id, itemId, req.Text, req.Checked)
+	if err != nil {
+		return nil, fmt.Errorf("error updating shopping list item: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, fmt.Errorf("shopping list item not found")
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+//encore:api auth method=DELETE path=/api/shopping-lists/:id/items/:itemId
+func DeleteShoppingListItem(ctx context.Context, id string, itemId string) (*ShoppingList, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	if err := requireShoppingListAccess(ctx, id, string(authResult), HouseholdRoleEditor); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM shopping_list_item
+		WHERE id = $2 AND shopping_list_id = $1
+	`, id, itemId)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting shopping list item: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, fmt.Errorf("shopping list item not found")
+	}
+
+	return getShoppingList(ctx, id)
+}
+
+func validateShoppingListItem(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("item text is required")
+	}
+	if utf8.RuneCountInString(text) > maxShoppingListItemLength {
+		return fmt.Errorf("item must be at most %d characters", maxShoppingListItemLength)
+	}
+	return nil
+}
+
+// addShoppingListItems appends items to the list. The list row is locked
+// while the items are counted, so concurrent additions cannot push the list
+// past maxShoppingListItems.
+func addShoppingListItems(ctx context.Context, listId string, texts []string, recipeId *string) error {
+	for _, text := range texts {
+		if err := validateShoppingListItem(text); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var itemCount int
+	err = tx.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM shopping_list_item WHERE shopping_list_id = l.id)
+		FROM shopping_list l
+		WHERE l.id = $1
+		FOR UPDATE
+	`, listId).Scan(&itemCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errShoppingListNotFound
+		}
+		return err
+	}
+	if itemCount+len(texts) > maxShoppingListItems {
+		return fmt.Errorf("a shopping list can have at most %d items", maxShoppingListItems)
+	}
+
+	for _, text := range texts {
+		if err := insertShoppingListItem(ctx, tx, listId, text, recipeId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertShoppingListItem(ctx context.Context, tx *sqldb.Tx, listId string, text string, recipeId *string) error {
+	itemId, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("error generating shopping list item ID: %w", err)
+	}
+
+	// clock_timestamp keeps items added together in order.
+	_, err = tx.Exec(ctx, `
+		INSERT INTO shopping_list_item (id, shopping_list_id, text, recipe_id, created_at)
+		VALUES ($1, $2, $3, $4, clock_timestamp())
+	`, itemId.String(), listId, text, recipeId)
+	if err != nil {
+		return fmt.Errorf("error adding shopping list item: %w", err)
+	}
+
+	return nil
+}
+
+// requireShoppingListAccess checks that the profile may see the list, or
+// change it when minRole is editor.
+func requireShoppingListAccess(ctx context.Context, id string, profileId string, minRole string) error {
+	var ownerId, householdId *string
+	err := db.QueryRow(ctx, `
+		SELECT profile_id, household_id
+		FROM shopping_list
+		WHERE id = $1
+	`, id).Scan(&ownerId, &householdId)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errShoppingListNotFound
+		}
+		return err
+	}
+
+	return requireOwnerOrHouseholdRole(ctx, ownerId, householdId, profileId, minRole, errShoppingListNotFound)
+}
+
+func getShoppingList(ctx context.Context, id string) (*ShoppingList, error) {
+	list := &ShoppingList{Id: id, Items: []*ShoppingListItem{}}
+	err := db.QueryRow(ctx, `
+		SELECT household_id, name
+		FROM shopping_list
+		WHERE id = $1
+	`, id).Scan(&list.HouseholdId, &list.Name)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errShoppingListNotFound
+		}
+		return nil, err
+	}
+
+	if err := loadShoppingListItems(ctx, []*ShoppingList{list}); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// loadShoppingListItems fills in the items of the lists, oldest first.
+func loadShoppingListItems(ctx context.Context, lists []*ShoppingList) error {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*ShoppingList, len(lists))
+	listIds := make([]string, 0, len(lists))
+	for _, list := range lists {
+		byId[list.Id] = list
+		listIds = append(listIds, list.Id)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT shopping_list_id, id, text, checked, recipe_id
+		FROM shopping_list_item
+		WHERE shopping_list_id = ANY($1)
+		ORDER BY created_at, id
+	`, listIds)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var listId string
+		item := &ShoppingListItem{}
+		if err := rows.Scan(&listId, &item.Id, &item.Text, &item.Checked, &item.RecipeId); err != nil {
+			return err
+		}
+		byId[listId].Items = append(byId[listId].Items, item)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return nil
+}
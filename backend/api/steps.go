@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"encore.dev/storage/sqldb"
+
+	"encore.app/backend/api/recipetext"
+)
+
+// RecipeStep is one instruction, with the timers and temperature detected
+// in its text so cook mode can offer them.
+type RecipeStep struct {
+	Position int `json:"position"`
+	// Section is the heading the step appears under, e.g. "For the sauce".
+	Section  string       `json:"section"`
+	Text     string       `json:"text"`
+	Timers   []*StepTimer `json:"timers"`
+	TempDegF int16        `json:"temp_deg_f"` // 0 when the step mentions no temperature
+	// Ingredients are the ingredient lines the step appears to use.
+	Ingredients []string `json:"ingredients"`
+}
+
+// StepTimer is a duration mentioned in a step. Ranges such as "8 to 10
+// hours" have a different minimum and maximum.
+type StepTimer struct {
+	Label      string `json:"label"`
+	MinSeconds int    `json:"min_seconds"`
+	MaxSeconds int    `json:"max_seconds"`
+}
+
+type RecipeStepsResponse struct {
+	Steps []*RecipeStep `json:"steps"`
+	// Redirect is set when the recipe was found under an old URL.
+	Redirect *Redirect `json:"redirect,omitempty"`
+}
+
+// GetRecipeSteps returns the recipe's instructions as structured steps for
+// cook mode. Steps are parsed on first request after the recipe changes.
+//
+//encore:api public method=GET path=/api/recipes/:username/:slug/steps tag:read
+func GetRecipeSteps(ctx context.Context, username string, slug string) (*RecipeStepsResponse, error) {
+	recipe, err := GetRecipe(ctx, username, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := getRecipeSteps(ctx, recipe)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecipeStepsResponse{Steps: steps, Redirect: recipe.Redirect}, nil
+}
+
+// getRecipeSteps returns the stored steps, parsing and storing them first
+// if the recipe changed since they were last parsed. The recipe row is
+// locked while re-parsing so concurrent requests do not both replace the
+// steps.
+func getRecipeSteps(ctx context.Context, recipe *Recipe) ([]*RecipeStep, error) {
+	var stale bool
+	err := db.QueryRow(ctx, `
+		SELECT steps_parsed_at IS NULL OR steps_parsed_at < updated_at
+		FROM recipe
+		WHERE id = $1
+	`, recipe.Id).Scan(&stale)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stale {
+		return loadRecipeSteps(ctx, recipe.Id)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Check again once the row is locked, since another request may have
+	// parsed the steps in the meantime.
+	var instructions, ingredients string
+	err = tx.QueryRow(ctx, `
+		SELECT instructions, ingredients, steps_parsed_at IS NULL OR steps_parsed_at < updated_at
+		FROM recipe
+		WHERE id = $1
+		FOR UPDATE
+	`, recipe.Id).Scan(&instructions, &ingredients, &stale)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stale {
+		tx.Rollback()
+		return loadRecipeSteps(ctx, recipe.Id)
+	}
+
+	steps := parseRecipeSteps(instructions, ingredients)
+	if err := saveRecipeSteps(ctx, tx, recipe.Id, steps); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// parseRecipeSteps converts the steps parsed from the recipe text.
+func parseRecipeSteps(instructions string, ingredients string) []*RecipeStep {
+	steps := []*RecipeStep{}
+	for _, s := range recipetext.ParseSteps(instructions, ingredients) {
+		step := &RecipeStep{
+			Position:    s.Position,
+			Section:     s.Section,
+			Text:        s.Text,
+			Timers:      []*StepTimer{},
+			TempDegF:    int16(s.TempDegF),
+			Ingredients: s.Ingredients,
+		}
+		for _, t := range s.Timers {
+			step.Timers = append(step.Timers, &StepTimer{Label: t.Label, MinSeconds: t.MinSeconds, MaxSeconds: t.MaxSeconds})
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+func loadRecipeSteps(ctx context.Context, recipeId string) ([]*RecipeStep, error) {
+	rows, err := db.Query(ctx, `
+		SELECT position, section, text, timers, temp_deg_f, ingredients
+		FROM recipe_step
+		WHERE recipe_id = $1
+		ORDER BY position
+	`, recipeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []*RecipeStep{}
+	for rows.Next() {
+		s := &RecipeStep{}
+		var timersJSON []byte
+		if err := rows.Scan(&s.Position, &s.Section, &s.Text, &timersJSON, &s.TempDegF, &s.Ingredients); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(timersJSON, &s.Timers); err != nil {
+			return nil, fmt.Errorf("error reading step timers: %w", err)
+		}
+		steps = append(steps, s)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return steps, nil
+}
+
+// saveRecipeSteps replaces the stored steps within the caller's transaction.
+func saveRecipeSteps(ctx context.Context, tx *sqldb.Tx, recipeId string, steps []*RecipeStep) error {
+	_, err := tx.Exec(ctx, `DELETE FROM recipe_step WHERE recipe_id = $1`, recipeId)
+	if err != nil {
+		return fmt.Errorf("error saving steps: %w", err)
+	}
+
+	for _, step := range steps {
+		timersJSON, err := json.Marshal(step.Timers)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO recipe_step (recipe_id, position, section, text, timers, temp_deg_f, ingredients)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, recipeId, step.Position, step.Section, step.Text, timersJSON, step.TempDegF, step.Ingredients)
+		if err != nil {
+			return fmt.Errorf("error saving steps: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE recipe SET steps_parsed_at = NOW() WHERE id = $1`, recipeId)
+	if err != nil {
+		return fmt.Errorf("error saving steps: %w", err)
+	}
+	return nil
+}
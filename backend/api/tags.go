@@ -0,0 +1,403 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+)
+
+const (
+	maxTagLength         = 30
+	maxCustomTagsPerUser = 50
+)
+
+// tagNamePattern is the characters tag names may use. Tag names are put into
+// AI prompts, so punctuation that could structure a prompt is not allowed.
+var tagNamePattern = regexp.MustCompile(`^[\p{L}\p{N}](?:[\p{L}\p{N} '&-]*[\p{L}\p{N}])?$`)
+
+// tagCategories are the kinds of tag, in the order they are presented.
+var tagCategories = []string{"course", "cuisine", "diet", "main_ingredient", "other"}
+
+type Tag struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Category is one of "course", "cuisine", "diet", "main_ingredient" or "other".
+	Category string `json:"category"`
+	// Global tags are available to everyone and can only be changed by admins.
+	Global bool `json:"global"`
+}
+
+type TagListResponse struct {
+	Tags []*Tag `json:"tags"`
+}
+
+type SaveTagRequest struct {
+	Name string `json:"name"`
+	// Category defaults to "other" for new tags and is left unchanged when
+	// renaming if empty.
+	Category string `json:"category"`
+	// Global creates a tag for everyone instead of a custom tag. Admins only.
+	Global bool `json:"global"`
+}
+
+// GetMyTags returns the caller's tag vocabulary: the global tags plus their
+// custom tags.
+//
+//encore:api auth method=GET path=/api/tags tag:read
+func GetMyTags(ctx context.Context) (*TagListResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	tags, err := getTagVocabulary(ctx, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TagListResponse{Tags: tags}, nil
+}
+
+//encore:api auth method=POST path=/api/tags
+func CreateTag(ctx context.Context, req *SaveTagRequest) (*Tag, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+	if req.Global && !isAdmin() {
+		return nil, fmt.Errorf("not authorized to create global tags")
+	}
+
+	name, err := validateTagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	category := req.Category
+	if category == "" {
+		category = "other"
+	}
+	if !isValidTagCategory(category) {
+		return nil, fmt.Errorf("invalid tag category: %s", category)
+	}
+
+	profileId := string(authResult)
+	if req.Global {
+		profileId = ""
+	} else {
+		var customTags int
+		err := db.QueryRow(ctx, `SELECT COUNT(*) FROM tag WHERE profile_id = $1`, profileId).Scan(&customTags)
+		if err != nil {
+			return nil, err
+		}
+		if customTags >= maxCustomTagsPerUser {
+			return nil, fmt.Errorf("you cannot have more than %d custom tags", maxCustomTagsPerUser)
+		}
+	}
+
+	if err := checkTagNameAvailable(ctx, name, string(authResult), "", req.Global); err != nil {
+		return nil, err
+	}
+
+	tagId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating tag ID: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO tag (id, profile_id, name, category)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+	`, tagId.String(), profileId, name, category)
+	if err != nil {
+		return nil, fmt.Errorf("error saving tag: %w", err)
+	}
+
+	if req.Global {
+		if err := mergeCustomTags(ctx, tx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Tag{Id: tagId.String(), Name: name, Category: category, Global: req.Global}, nil
+}
+
+// RenameTag renames a tag, and optionally changes its category, updating
+// every use of it on recipes: the caller's own recipes for a custom tag, or
+// all recipes for a global tag.
+//
+//encore:api auth method=POST path=/api/tags/:id
+func RenameTag(ctx context.Context, id string, req *SaveTagRequest) (*Tag, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	tag, err := getEditableTag(ctx, id, string(authResult))
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := validateTagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTagNameAvailable(ctx, name, string(authResult), id, tag.Global); err != nil {
+		return nil, err
+	}
+	if req.Category != "" {
+		if !isValidTagCategory(req.Category) {
+			return nil, fmt.Errorf("invalid tag category: %s", req.Category)
+		}
+		tag.Category = req.Category
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `UPDATE tag SET name = $2, category = $3 WHERE id = $1`, id, name, tag.Category)
+	if err != nil {
+		return nil, fmt.Errorf("error renaming tag: %w", err)
+	}
+
+	// Recipes may carry the tag in a different case, as mergeCustomTags
+	// allows, so match it case-insensitively. A recipe that already has the
+	// new name keeps it only once.
+	_, err = tx.Exec(ctx, `
+		UPDATE recipe
+		SET tags = ARRAY(
+			SELECT t
+			FROM (
+				SELECT DISTINCT ON (LOWER(t)) t, i
+				FROM (
+					SELECT CASE WHEN LOWER(t) = LOWER($1) THEN $2 ELSE t END, i
+					FROM unnest(tags) WITH ORDINALITY AS u(t, i)
+				) AS renamed(t, i)
+				ORDER BY LOWER(t), i
+			) AS deduplicated
+			ORDER BY i
+		)
+		WHERE LOWER($1) = ANY(SELECT LOWER(unnest(tags))) AND ($3 OR profile_id = $4)
+	`, tag.Name, name, tag.Global, string(authResult))
+	if err != nil {
+		return nil, fmt.Errorf("error renaming tag on recipes: %w", err)
+	}
+
+	if tag.Global {
+		if err := mergeCustomTags(ctx, tx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	tag.Name = name
+	return tag, nil
+}
+
+// DeleteTag deletes a tag and removes it from recipes, with the same scope
+// as RenameTag.
+//
+//encore:api auth method=DELETE path=/api/tags/:id
+func DeleteTag(ctx context.Context, id string) error {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return fmt.Errorf("not authorized")
+	}
+
+	tag, err := getEditableTag(ctx, id, string(authResult))
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `DELETE FROM tag WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting tag: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE recipe
+		SET tags = ARRAY(
+			SELECT t
+			FROM unnest(tags) WITH ORDINALITY AS u(t, i)
+			WHERE LOWER(t) <> LOWER($1)
+			ORDER BY i
+		)
+		WHERE LOWER($1) = ANY(SELECT LOWER(unnest(tags))) AND ($2 OR profile_id = $3)
+	`, tag.Name, tag.Global, string(authResult))
+	if err != nil {
+		return fmt.Errorf("error removing tag from recipes: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// getTagVocabulary returns the tags the profile can use: the global tags
+// plus their custom tags.
+func getTagVocabulary(ctx context.Context, profileId string) ([]*Tag, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, name, category, profile_id IS NULL
+		FROM tag
+		WHERE profile_id IS NULL OR profile_id = $1
+		ORDER BY LOWER(name)
+	`, profileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		t := &Tag{}
+		if err := rows.Scan(&t.Id, &t.Name, &t.Category, &t.Global); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return tags, nil
+}
+
+// getEditableTag returns a tag the caller may change: one of their custom
+// tags, or a global tag if they are an admin.
+func getEditableTag(ctx context.Context, id string, profileId string) (*Tag, error) {
+	tag := &Tag{Id: id}
+	var ownerId string
+	err := db.QueryRow(ctx, `
+		SELECT name, category, profile_id IS NULL, COALESCE(profile_id, '')
+		FROM tag
+		WHERE id = $1
+	`, id).Scan(&tag.Name, &tag.Category, &tag.Global, &ownerId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("tag not found")
+		}
+		return nil, err
+	}
+
+	if tag.Global && !isAdmin() {
+		return nil, fmt.Errorf("not authorized to change global tags")
+	}
+	if !tag.Global && ownerId != profileId {
+		return nil, fmt.Errorf("tag not found")
+	}
+
+	return tag, nil
+}
+
+// tagNames returns the names the model may choose from. Like tagGuideline,
+// it leaves out names that are not safe to put into a prompt.
+func tagNames(tags []*Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if isPromptSafeTagName(t.Name) {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+func isValidTagCategory(category string) bool {
+	for _, c := range tagCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTagName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "", fmt.Errorf("tag name is required")
+	}
+	if len(name) > maxTagLength {
+		return "", fmt.Errorf("tag name cannot be longer than %d characters", maxTagLength)
+	}
+	if !tagNamePattern.MatchString(name) {
+		return "", fmt.Errorf("tag names can only contain letters, numbers, spaces, hyphens, apostrophes and ampersands")
+	}
+	return name, nil
+}
+
+// checkTagNameAvailable returns an error if the name clashes, ignoring case,
+// with a tag other than exceptId: a global tag, or for a custom tag also one
+// of the profile's custom tags. Custom tags with the name of a global tag
+// are merged into it by mergeCustomTags.
+func checkTagNameAvailable(ctx context.Context, name string, profileId string, exceptId string, global bool) error {
+	var taken bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM tag
+			WHERE LOWER(name) = LOWER($1) AND (profile_id IS NULL OR (NOT $4 AND profile_id = $2)) AND id <> $3
+		)
+	`, name, profileId, exceptId, global).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("a tag with this name already exists")
+	}
+
+	return nil
+}
+
+// mergeCustomTags deletes every custom tag with the name of a global tag,
+// ignoring case, so no one has the tag twice. Recipes that used a custom
+// spelling switch to the global one.
+func mergeCustomTags(ctx context.Context, tx *sqldb.Tx, name string) error {
+	_, err := tx.Exec(ctx, `
+		WITH merged AS (
+			DELETE FROM tag
+			WHERE profile_id IS NOT NULL AND LOWER(name) = LOWER($1)
+			RETURNING profile_id, name
+		)
+		UPDATE recipe r
+		SET tags = array_replace(r.tags, m.name, $1)
+		FROM merged m
+		WHERE r.profile_id = m.profile_id AND m.name <> $1 AND m.name = ANY(r.tags)
+	`, name)
+	if err != nil {
+		return fmt.Errorf("error merging custom tags: %w", err)
+	}
+
+	return nil
+}
+
+// isPromptSafeTagName reports whether a stored tag name may be put into a
+// prompt. Names saved before the current rules may not be.
+func isPromptSafeTagName(name string) bool {
+	return len(name) <= maxTagLength && tagNamePattern.MatchString(name)
+}
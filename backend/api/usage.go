@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/rlog"
+	"encore.dev/types/uuid"
+)
+
+// AI import limits, applied per profile. Monthly limits can be overridden
+// per profile in the ai_quota table.
+const (
+	maxAiImportsPerMinute   = 3
+	maxAiImportsPerHour     = 20
+	defaultMonthlyAiImports = 100
+	defaultMonthlyAiTokens  = 500000
+	aiImportKindImage       = "image"
+	aiImportKindText        = "text"
+
+	// aiUsageKindTagging is background auto-tagging, which is tracked but
+	// does not count towards the profile's limits.
+	aiUsageKindTagging = "tagging"
+)
+
+type AiUsageResponse struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Imports     int       `json:"imports"`
+	ImportLimit int       `json:"import_limit"`
+	Tokens      int       `json:"tokens"`
+	TokenLimit  int       `json:"token_limit"`
+}
+
+type SetAiQuotaRequest struct {
+	MonthlyImports int `json:"monthly_imports"`
+	MonthlyTokens  int `json:"monthly_tokens"`
+}
+
+// GetMyUsage returns the caller's AI import usage for the current calendar
+// month, which is the period the quota applies to.
+//
+//encore:api auth method=GET path=/api/me/usage tag:read
+func GetMyUsage(ctx context.Context) (*AiUsageResponse, error) {
+	authResult, authBool := auth.UserID()
+	if !authBool {
+		return nil, fmt.Errorf("not authorized")
+	}
+
+	return getAiUsage(ctx, string(authResult))
+}
+
+//encore:api auth method=POST path=/api/admin/profiles/:id/ai-quota
+func AdminSetAiQuota(ctx context.Context, id string, req *SetAiQuotaRequest) (*AiUsageResponse, error) {
+	adminId, err := requireAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.MonthlyImports < 0 || req.MonthlyTokens < 0 {
+		return nil, fmt.Errorf("quota must be positive")
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO ai_quota (profile_id, monthly_imports, monthly_tokens)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (profile_id) DO UPDATE SET monthly_imports=$2, monthly_tokens=$3, updated_at=NOW()
+	`, id, req.MonthlyImports, req.MonthlyTokens)
+	if err != nil {
+		return nil, fmt.Errorf("error saving quota: %w", err)
+	}
+
+	reason := fmt.Sprintf("%d imports, %d tokens per month", req.MonthlyImports, req.MonthlyTokens)
+	if err := logModerationAction(ctx, adminId, "set_ai_quota", "profile", id, reason); err != nil {
+		return nil, err
+	}
+
+	return getAiUsage(ctx, id)
+}
+
+func getAiUsage(ctx context.Context, profileId string) (*AiUsageResponse, error) {
+	usage := &AiUsageResponse{}
+	err := db.QueryRow(ctx, `
+		SELECT
+			date_trunc('month', NOW()),
+			date_trunc('month', NOW()) + INTERVAL '1 month',
+			(SELECT COUNT(*) FROM ai_usage
+			 WHERE profile_id = $1 AND kind <> 'tagging' AND status <> 'failed' AND created_at >= date_trunc('month', NOW())),
+			COALESCE((SELECT SUM(total_tokens) FROM ai_usage
+			 WHERE profile_id = $1 AND kind <> 'tagging' AND created_at >= date_trunc('month', NOW())), 0),
+			COALESCE((SELECT monthly_imports FROM ai_quota WHERE profile_id = $1), $2),
+			COALESCE((SELECT monthly_tokens FROM ai_quota WHERE profile_id = $1), $3)
+	`, profileId, defaultMonthlyAiImports, defaultMonthlyAiTokens).Scan(
+		&usage.PeriodStart,
+		&usage.PeriodEnd,
+		&usage.Imports,
+		&usage.Tokens,
+		&usage.ImportLimit,
+		&usage.TokenLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// startAiUsage checks the profile's rate limits and monthly quota and, if
+// the import is allowed, records it as pending. The check and insert run
+// under a per-profile lock so concurrent imports cannot overshoot the limits.
+// Failed imports count towards the rate limits but not the monthly imports.
+func startAiUsage(ctx context.Context, profileId string, kind string) (string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('ai_usage:' || $1))`, profileId)
+	if err != nil {
+		return "", err
+	}
+
+	var lastMinute, lastHour int
+	err = tx.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 minute'),
+			COUNT(*)
+		FROM ai_usage
+		WHERE profile_id = $1 AND kind <> 'tagging' AND created_at > NOW() - INTERVAL '1 hour'
+	`, profileId).Scan(&lastMinute, &lastHour)
+	if err != nil {
+		return "", err
+	}
+	if lastMinute >= maxAiImportsPerMinute || lastHour >= maxAiImportsPerHour {
+		return "", fmt.Errorf("too many recipe imports, please try again later")
+	}
+
+	usage, err := getAiUsage(ctx, profileId)
+	if err != nil {
+		return "", err
+	}
+	if usage.Imports >= usage.ImportLimit || usage.Tokens >= usage.TokenLimit {
+		return "", fmt.Errorf("monthly recipe import quota reached, it resets on %s", usage.PeriodEnd.Format("January 2"))
+	}
+
+	usageId, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("error generating usage ID: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ai_usage (id, profile_id, kind)
+		VALUES ($1, $2, $3)
+	`, usageId.String(), profileId, kind)
+	if err != nil {
+		return "", fmt.Errorf("error recording usage: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return usageId.String(), nil
+}
+
+// finishAiUsage records the outcome of an import and the tokens it consumed.
+// usage may be nil if the request never got a response. Errors are only
+// logged so they do not fail an import that already succeeded.
+func finishAiUsage(ctx context.Context, usageId string, usage *OpenAIUsage, importErr error) {
+	if usage == nil {
+		usage = &OpenAIUsage{}
+	}
+	status := "succeeded"
+	if importErr != nil {
+		status = "failed"
+	}
+
+	_, err := db.Exec(ctx, `
+		UPDATE ai_usage
+		SET model = $2, prompt_tokens = $3, completion_tokens = $4, total_tokens = $5, status = $6
+		WHERE id = $1
+	`, usageId, usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, status)
+	if err != nil {
+		rlog.Error("error recording ai usage", "usage_id", usageId, "err", err)
+	}
+}
+
+// recordAiUsage records a completed call that is not subject to the limits.
+func recordAiUsage(ctx context.Context, profileId string, kind string, usage *OpenAIUsage, callErr error) {
+	usageId, err := uuid.NewV4()
+	if err != nil {
+		rlog.Error("error generating usage ID", "err", err)
+		return
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO ai_usage (id, profile_id, kind)
+		VALUES ($1, $2, $3)
+	`, usageId.String(), profileId, kind)
+	if err != nil {
+		rlog.Error("error recording ai usage", "profile_id", profileId, "err", err)
+		return
+	}
+
+	finishAiUsage(ctx, usageId.String(), usage, callErr)
+}
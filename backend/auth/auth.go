@@ -2,59 +2,93 @@ package auth
 
 import (
 	"context"
+	"strings"
 
 	"encore.dev/beta/auth"
-	firebase "firebase.google.com/go/v4"
-	fbauth "firebase.google.com/go/v4/auth"
-	"go4.org/syncutil"
-	"google.golang.org/api/option"
-)
-
-var secrets struct {
-	// FirebasePrivateKey is the JSON credentials for calling Firebase.
-	FirebasePrivateKey string
-}
-
-var (
-	fbAuth    *fbauth.Client
-	setupOnce syncutil.Once
 )
 
 // Data represents the user's data stored in Firebase Auth.
 type UserData struct {
 	// Email is the user's email.
 	Email string
+
+	// EmailVerified is whether the provider has verified the email.
+	EmailVerified bool
+
+	// Name and Picture are the display name and photo URL from the
+	// provider, e.g. the user's Google account.
+	Name    string
+	Picture string
+
+	// SignInProvider is how the user signed in, e.g. "google.com" or "password".
+	SignInProvider string
+
+	// IsAdmin is set from the "admin" custom claim on the Firebase user.
+	IsAdmin bool
+
+	// TokenScope is the scope of the API token the request was made with,
+	// or empty when the user signed in through the auth provider.
+	TokenScope string
 }
 
-// ValidateToken validates an auth token against Firebase Auth.
+// ValidateToken validates an auth token. API tokens are looked up in the
+// database, anything else is checked by the configured token verifier, which
+// is Firebase Auth unless the Provider config says otherwise.
 //
 //encore:authhandler
 func ValidateToken(ctx context.Context, token string) (auth.UID, *UserData, error) {
-	if err := setupFB(); err != nil {
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		return validateApiToken(ctx, token)
+	}
+
+	if uid, usr, ok := tokenCache.get(token); ok {
+		return uid, usr, nil
+	}
+
+	verifier, err := getVerifier()
+	if err != nil {
 		return "", nil, err
 	}
-	tok, err := fbAuth.VerifyIDToken(ctx, token)
+	tok, err := verifier.Verify(ctx, token)
 	if err != nil {
 		return "", nil, err
 	}
 
 	email, _ := tok.Claims["email"].(string)
+	emailVerified, _ := tok.Claims["email_verified"].(bool)
+	name, _ := tok.Claims["name"].(string)
+	picture, _ := tok.Claims["picture"].(string)
+	isAdmin, _ := tok.Claims["admin"].(bool)
 	uid := auth.UID(tok.UID)
 
 	usr := &UserData{
-		Email: email,
+		Email:          email,
+		EmailVerified:  emailVerified,
+		Name:           name,
+		Picture:        picture,
+		SignInProvider: tok.SignInProvider,
+		IsAdmin:        isAdmin,
 	}
+	tokenCache.put(token, uid, usr, tok.ExpiresAt)
 	return uid, usr, nil
 }
 
-// setupFB ensures Firebase Auth is setup.
-func setupFB() error {
-	return setupOnce.Do(func() error {
-		opt := option.WithCredentialsJSON([]byte(secrets.FirebasePrivateKey))
-		app, err := firebase.NewApp(context.Background(), nil, opt)
-		if err == nil {
-			fbAuth, err = app.Auth(context.Background())
-		}
+// DeleteUser removes the user's API tokens and their sign-in account, if the
+// configured provider stores one. It is called by the api service when a user
+// deletes their account.
+//
+//encore:api private method=DELETE path=/auth/users/:uid
+func DeleteUser(ctx context.Context, uid string) error {
+	if err := deleteApiTokens(ctx, uid); err != nil {
+		return err
+	}
+
+	verifier, err := getVerifier()
+	if err != nil {
 		return err
-	})
+	}
+	if deleter, ok := verifier.(userDeleter); ok {
+		return deleter.DeleteUser(ctx, uid)
+	}
+	return nil
 }
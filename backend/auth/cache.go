@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"encore.dev/beta/auth"
+)
+
+const (
+	// verifiedTokenTTL is how long a verified token is trusted without
+	// verifying it again. It bounds how long a revoked admin claim lingers.
+	verifiedTokenTTL = time.Minute
+
+	// maxCachedTokens bounds the cache size; expired entries are pruned when
+	// it fills up.
+	maxCachedTokens = 10000
+)
+
+var tokenCache = &verifiedTokenCache{entries: map[[sha256.Size]byte]*cachedToken{}}
+
+type cachedToken struct {
+	uid       auth.UID
+	userData  *UserData
+	expiresAt time.Time
+}
+
+// verifiedTokenCache remembers recently verified provider tokens, keyed by
+// their hash so the tokens themselves are not kept in memory.
+type verifiedTokenCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*cachedToken
+}
+
+func (c *verifiedTokenCache) get(token string) (auth.UID, *UserData, bool) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return "", nil, false
+	}
+
+	// Hand out a copy so callers cannot modify the cached user data.
+	usr := *entry.userData
+	return entry.uid, &usr, true
+}
+
+// put caches a verified token until the TTL passes or the token expires,
+// whichever comes first.
+func (c *verifiedTokenCache) put(token string, uid auth.UID, userData *UserData, tokenExpiresAt time.Time) {
+	expiresAt := time.Now().Add(verifiedTokenTTL)
+	if !tokenExpiresAt.IsZero() && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+	usr := *userData
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedTokens {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// Every entry is still valid, so start over rather than grow unbounded.
+		if len(c.entries) >= maxCachedTokens {
+			c.entries = map[[sha256.Size]byte]*cachedToken{}
+		}
+	}
+	c.entries[key] = &cachedToken{uid: uid, userData: &usr, expiresAt: expiresAt}
+}
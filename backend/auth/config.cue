@@ -0,0 +1,23 @@
+// Firebase is used everywhere except in tests, which sign their own tokens.
+Provider: [
+	if #Meta.Environment.Type == "test" {"local"},
+	"firebase",
+][0]
+
+LocalIssuer:   "my-recipe-app-local"
+LocalAudience: "my-recipe-app"
+
+// Self-hosters add the PEM encoded public keys they sign tokens with. Tests
+// use the key pair in localjwt/testkey_test.go, whose private key is only
+// compiled into tests.
+LocalPublicKeys: [
+	if #Meta.Environment.Type == "test" {[
+		"""
+			-----BEGIN PUBLIC KEY-----
+			MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAED9NIhQLRkRlHnuNULshMG4d0kq50
+			TTD80jmNAaUJSudlrSYwdS+2GTymkl0JAML7johRj1kHXIsP+AmPNyPdYw==
+			-----END PUBLIC KEY-----
+			""",
+	]},
+	[],
+][0]
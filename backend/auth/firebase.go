@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	firebase "firebase.google.com/go/v4"
+	fbauth "firebase.google.com/go/v4/auth"
+	"go4.org/syncutil"
+	"google.golang.org/api/option"
+)
+
+var secrets struct {
+	// FirebasePrivateKey is the JSON credentials for calling Firebase.
+	FirebasePrivateKey string
+}
+
+var (
+	fbAuth    *fbauth.Client
+	setupOnce syncutil.Once
+)
+
+// firebaseVerifier verifies Firebase Auth ID tokens.
+type firebaseVerifier struct{}
+
+func (firebaseVerifier) Verify(ctx context.Context, token string) (*verifiedToken, error) {
+	if err := setupFB(); err != nil {
+		return nil, err
+	}
+	tok, err := fbAuth.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifiedToken{
+		UID:            tok.UID,
+		Claims:         tok.Claims,
+		SignInProvider: tok.Firebase.SignInProvider,
+		ExpiresAt:      time.Unix(tok.Expires, 0),
+	}, nil
+}
+
+func (firebaseVerifier) DeleteUser(ctx context.Context, uid string) error {
+	if err := setupFB(); err != nil {
+		return err
+	}
+	return fbAuth.DeleteUser(ctx, uid)
+}
+
+// setupFB ensures Firebase Auth is setup.
+func setupFB() error {
+	return setupOnce.Do(func() error {
+		opt := option.WithCredentialsJSON([]byte(secrets.FirebasePrivateKey))
+		app, err := firebase.NewApp(context.Background(), nil, opt)
+		if err == nil {
+			fbAuth, err = app.Auth(context.Background())
+		}
+		return err
+	})
+}
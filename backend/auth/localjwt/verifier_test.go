@@ -0,0 +1,142 @@
+package localjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newTestVerifier(t *testing.T) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(testIssuer, testAudience, []string{testPublicKeyPEM})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return v
+}
+
+func TestVerifyTestToken(t *testing.T) {
+	token, err := signTestToken("user-1", map[string]interface{}{"email": "cook@example.com"})
+	if err != nil {
+		t.Fatalf("signTestToken: %v", err)
+	}
+
+	verified, err := newTestVerifier(t).Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if verified.Subject != "user-1" {
+		t.Errorf("Subject = %q, want %q", verified.Subject, "user-1")
+	}
+	if verified.Claims["email"] != "cook@example.com" {
+		t.Errorf("email claim = %v, want %q", verified.Claims["email"], "cook@example.com")
+	}
+	if until := time.Until(verified.ExpiresAt); until <= 0 || until > time.Hour {
+		t.Errorf("ExpiresAt = %v, want within the next hour", verified.ExpiresAt)
+	}
+}
+
+func TestVerifyRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		uid    string
+		claims map[string]interface{}
+	}{
+		{"wrong issuer", "user-1", map[string]interface{}{"iss": "someone-else"}},
+		{"wrong audience", "user-1", map[string]interface{}{"aud": "another-app"}},
+		{"expired", "user-1", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"no expiry", "user-1", map[string]interface{}{"exp": nil}},
+		{"no subject", "", nil},
+	}
+
+	v := newTestVerifier(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := signTestToken(test.uid, test.claims)
+			if err != nil {
+				t.Fatalf("signTestToken: %v", err)
+			}
+			if _, err := v.Verify(token); err == nil {
+				t.Errorf("Verify accepted the token")
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherKeysAndAlgorithms(t *testing.T) {
+	claims := jwt.MapClaims{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyToken, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An HMAC token keyed with the public key must not pass as signed by it.
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testPublicKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTestVerifier(t)
+	for name, token := range map[string]string{"other key": otherKeyToken, "HS256": hmacToken, "none": noneToken} {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("%s: Verify accepted the token", name)
+		}
+	}
+}
+
+func TestVerifyAcceptsAnyConfiguredKey(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	v, err := NewVerifier(testIssuer, testAudience, []string{otherPEM, testPublicKeyPEM})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	token, err := signTestToken("user-1", nil)
+	if err != nil {
+		t.Fatalf("signTestToken: %v", err)
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestNewVerifierRequiresConfig(t *testing.T) {
+	if _, err := NewVerifier("", testAudience, []string{testPublicKeyPEM}); err == nil {
+		t.Error("accepted an empty issuer")
+	}
+	if _, err := NewVerifier(testIssuer, testAudience, nil); err == nil {
+		t.Error("accepted no keys")
+	}
+	if _, err := NewVerifier(testIssuer, testAudience, []string{"not a key"}); err == nil {
+		t.Error("accepted an invalid key")
+	}
+}
@@ -0,0 +1,15 @@
+-- Long-lived tokens users create for scripts and imports
+CREATE TABLE api_token (
+    id TEXT PRIMARY KEY,
+    user_id VARCHAR(128) NOT NULL,
+    name TEXT NOT NULL,
+    -- SHA-256 of the token; the token itself is only shown once, on creation
+    token_hash TEXT NOT NULL UNIQUE,
+    scope TEXT NOT NULL CHECK (scope IN ('read', 'write')),
+    expires_at TIMESTAMPTZ NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_api_token_user_id ON api_token(user_id);
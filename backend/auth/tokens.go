@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.dev/beta/auth"
+	"encore.dev/storage/sqldb"
+	"encore.dev/types/uuid"
+)
+
+// Define a database named 'auth' for the API tokens users create.
+var db = sqldb.NewDatabase("auth", sqldb.DatabaseConfig{
+	Migrations: "./migrations",
+})
+
+const (
+	// apiTokenPrefix distinguishes API tokens from provider ID tokens.
+	apiTokenPrefix = "rcp_"
+
+	// Scopes an API token can be granted. Read tokens can only call
+	// endpoints tagged "read".
+	TokenScopeRead  = "read"
+	TokenScopeWrite = "write"
+
+	maxApiTokensPerUser   = 20
+	maxApiTokenNameLength = 100
+
+	// lastUsedResolution limits how often using a token writes to the database.
+	lastUsedResolution = time.Minute
+)
+
+type CreateApiTokenRequest struct {
+	Name string `json:"name"`
+	// Scope is "read" or "write".
+	Scope string `json:"scope"`
+	// ExpiresInDays is how long the token is valid for; 0 means it never expires.
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+type ApiToken struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateApiTokenResponse struct {
+	ApiToken *ApiToken `json:"api_token"`
+	// Token is the secret to send as the bearer token. It cannot be retrieved again.
+	Token string `json:"token"`
+}
+
+type ApiTokenListResponse struct {
+	ApiTokens []*ApiToken `json:"api_tokens"`
+}
+
+//encore:api auth method=POST path=/api/tokens
+func CreateApiToken(ctx context.Context, req *CreateApiTokenRequest) (*CreateApiTokenResponse, error) {
+	uid, err := RequireInteractiveUser()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("token name is required")
+	}
+	if len(name) > maxApiTokenNameLength {
+		return nil, fmt.Errorf("token name cannot be longer than %d characters", maxApiTokenNameLength)
+	}
+	if req.Scope != TokenScopeRead && req.Scope != TokenScopeWrite {
+		return nil, fmt.Errorf("invalid token scope: %s", req.Scope)
+	}
+	if req.ExpiresInDays < 0 {
+		return nil, fmt.Errorf("expiry must be positive")
+	}
+
+	var activeTokens int
+	err = db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM api_token
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, uid).Scan(&activeTokens)
+	if err != nil {
+		return nil, err
+	}
+	if activeTokens >= maxApiTokensPerUser {
+		return nil, fmt.Errorf("you cannot have more than %d active tokens", maxApiTokensPerUser)
+	}
+
+	tokenId, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("error generating token ID: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating token: %w", err)
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiToken := &ApiToken{Id: tokenId.String(), Name: name, Scope: req.Scope}
+	err = db.QueryRow(ctx, `
+		INSERT INTO api_token (id, user_id, name, token_hash, scope, expires_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $6 > 0 THEN NOW() + $6 * INTERVAL '1 day' END)
+		RETURNING expires_at, created_at
+	`, apiToken.Id, uid, name, hashApiToken(token), req.Scope, req.ExpiresInDays).Scan(&apiToken.ExpiresAt, &apiToken.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving token: %w", err)
+	}
+
+	return &CreateApiTokenResponse{ApiToken: apiToken, Token: token}, nil
+}
+
+//encore:api auth method=GET path=/api/tokens
+func ListApiTokens(ctx context.Context) (*ApiTokenListResponse, error) {
+	uid, err := RequireInteractiveUser()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, name, scope, expires_at, last_used_at, revoked_at, created_at
+		FROM api_token
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiTokens := []*ApiToken{}
+	for rows.Next() {
+		t := &ApiToken{}
+		if err := rows.Scan(&t.Id, &t.Name, &t.Scope, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		apiTokens = append(apiTokens, t)
+	}
+
+	// Check if there were any errors during iteration.
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate over rows: %v", err)
+	}
+
+	return &ApiTokenListResponse{ApiTokens: apiTokens}, nil
+}
+
+//encore:api auth method=DELETE path=/api/tokens/:id
+func RevokeApiToken(ctx context.Context, id string) error {
+	uid, err := RequireInteractiveUser()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE api_token
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND user_id = $2
+	`, id, uid)
+	if err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("token not found")
+	}
+
+	return nil
+}
+
+// validateApiToken looks up an active API token and records its use.
+func validateApiToken(ctx context.Context, token string) (auth.UID, *UserData, error) {
+	var tokenId, uid, scope string
+	var lastUsedAt *time.Time
+	err := db.QueryRow(ctx, `
+		SELECT id, user_id, scope, last_used_at
+		FROM api_token
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, hashApiToken(token)).Scan(&tokenId, &uid, &scope, &lastUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, fmt.Errorf("invalid token")
+		}
+		return "", nil, err
+	}
+
+	if lastUsedAt == nil || time.Since(*lastUsedAt) > lastUsedResolution {
+		_, err = db.Exec(ctx, `UPDATE api_token SET last_used_at = NOW() WHERE id = $1`, tokenId)
+		if err != nil {
+			return "", nil, fmt.Errorf("error updating token: %w", err)
+		}
+	}
+
+	// API tokens never carry admin rights, even when created by an admin.
+	return auth.UID(uid), &UserData{TokenScope: scope}, nil
+}
+
+// deleteApiTokens removes every API token belonging to the user.
+func deleteApiTokens(ctx context.Context, uid string) error {
+	_, err := db.Exec(ctx, `DELETE FROM api_token WHERE user_id = $1`, uid)
+	if err != nil {
+		return fmt.Errorf("error deleting tokens: %w", err)
+	}
+	return nil
+}
+
+// RequireInteractiveUser returns the caller's user ID, or an error if the
+// caller authenticated with an API token. Managing API tokens, and
+// account-level endpoints in the api service such as deleting the account,
+// need a signed-in session.
+func RequireInteractiveUser() (string, error) {
+	uid, ok := auth.UserID()
+	if !ok {
+		return "", fmt.Errorf("not authorized")
+	}
+	if userData, _ := auth.Data().(*UserData); userData == nil || userData.TokenScope != "" {
+		return "", fmt.Errorf("this can only be done while signed in, not with an API token")
+	}
+
+	return string(uid), nil
+}
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.dev/config"
+	"go4.org/syncutil"
+)
+
+// Token providers selectable with the Provider config.
+const (
+	providerFirebase = "firebase"
+	providerLocal    = "local"
+)
+
+type Config struct {
+	// Provider selects how auth tokens are verified: "firebase" verifies
+	// Firebase ID tokens, "local" verifies JWTs signed with LocalPublicKeys.
+	Provider config.String
+
+	// LocalIssuer and LocalAudience are the required "iss" and "aud" claims
+	// of locally signed tokens.
+	LocalIssuer   config.String
+	LocalAudience config.String
+
+	// LocalPublicKeys are PEM encoded RSA or ECDSA public keys. A token is
+	// accepted if any of them verifies its signature, which allows key rotation.
+	LocalPublicKeys config.Values[string]
+}
+
+var cfg = config.Load[*Config]()
+
+// verifiedToken is the identity carried by a successfully verified token.
+type verifiedToken struct {
+	UID            string
+	Claims         map[string]interface{}
+	SignInProvider string
+	ExpiresAt      time.Time
+}
+
+// tokenVerifier verifies a bearer token and returns the identity it carries.
+type tokenVerifier interface {
+	Verify(ctx context.Context, token string) (*verifiedToken, error)
+}
+
+// userDeleter is implemented by verifiers whose provider stores user accounts.
+type userDeleter interface {
+	DeleteUser(ctx context.Context, uid string) error
+}
+
+var (
+	verifier          tokenVerifier
+	setupVerifierOnce syncutil.Once
+)
+
+// getVerifier returns the token verifier selected by the Provider config.
+func getVerifier() (tokenVerifier, error) {
+	err := setupVerifierOnce.Do(func() error {
+		switch provider := cfg.Provider(); provider {
+		case providerFirebase, "":
+			verifier = &firebaseVerifier{}
+		case providerLocal:
+			v, err := newLocalVerifier(cfg.LocalIssuer(), cfg.LocalAudience(), cfg.LocalPublicKeys())
+			if err != nil {
+				return err
+			}
+			verifier = v
+		default:
+			return fmt.Errorf("unknown auth provider %q", provider)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return verifier, nil
+}
@@ -7,6 +7,7 @@ import { useContext, useState } from "react";
 import { api } from "../lib/client";
 import { FirebaseContext } from "../lib/firebase";
 import getRequestClient from "../lib/get-request-client";
+import { toUploadableImage } from "../lib/image-utils";
 import { ImageUploader } from "./image-uploader";
 import { Tabs, TabsContent, TabsList, TabsTrigger } from "./ui/tabs";
 import { Textarea } from "./ui/textarea";
@@ -36,7 +37,8 @@ export default function AddRecipeClient() {
         router.push(`/home/`);
     };
 
-    const handleImagesUpload = (files: File[]) => {
+    const handleImagesUpload = async (uploadedFiles: File[]) => {
+        const files = await Promise.all(uploadedFiles.map(toUploadableImage));
         const newFilesData: api.FileUpload[] = [];
         const newFilePreviews: (string | ArrayBuffer | null)[] = [];
         files.forEach((file: any) => {
@@ -8,6 +8,7 @@ import { v4 as uuidv4 } from "uuid";
 import { api } from "../lib/client";
 import { FirebaseContext } from "../lib/firebase";
 import getRequestClient from "../lib/get-request-client";
+import { toDisplayableImage } from "../lib/image-utils";
 import { useUploadThing } from "../lib/uploadthing-utils";
 import { cn } from "../lib/utils";
 import { ImageUploader } from "./image-uploader";
@@ -172,12 +173,12 @@ export default function EditRecipeClient({ recipe, username }: EditRecipeClientP
         },
     });
 
-    const handleImagesUpload = (files: File[]) => {
+    const handleImagesUpload = async (files: File[]) => {
         console.log("handle images upload");
         if (!files || files.length === 0) {
             return;
         }
-        const file = files[0];
+        const file = await toDisplayableImage(files[0]);
         setImageFile(file);
 
         const reader = new FileReader();
@@ -52,7 +52,7 @@ export const ImageUploader: React.FC<ImageUploaderProps> = ({ onImagesUpload: on
             maxFiles: maxFiles,
             maxSize: 10000000,
             multiple: true,
-            accept: { "image/png": [], "image/jpg": [], "image/jpeg": [], "image/webp": []  },
+            accept: { "image/png": [], "image/jpg": [], "image/jpeg": [], "image/gif": [], "image/webp": [], "image/heic": [".heic"], "image/heif": [".heif"] },
         });
 
     return (
@@ -76,7 +76,7 @@ export const ImageUploader: React.FC<ImageUploaderProps> = ({ onImagesUpload: on
             </div>
             {fileRejections.length !== 0 && (
                 <div className="text-xl pt-4">
-                    Images must be less than 10MB and of type png, jpg, or jpeg
+                    Images must be less than 10MB and of type png, jpg, gif, webp or heic
                 </div>
             )}
         </>
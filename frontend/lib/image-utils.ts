@@ -0,0 +1,56 @@
+// The server only decodes JPEG, PNG and GIF. Browsers that can display
+// other formats (e.g. Safari and HEIC photos from an iPhone) convert them
+// to JPEG before uploading.
+const serverImageTypes = ["image/jpeg", "image/png", "image/gif"];
+
+const isHeic = (file: File) =>
+    /^image\/hei[cf]/.test(file.type) || /\.hei[cf]$/i.test(file.name);
+
+// toUploadableImage returns the file as is if the server can read it, and
+// otherwise an upright JPEG drawn by the browser. If the browser cannot
+// decode the file either, the original is returned and the server explains
+// which formats it accepts.
+export async function toUploadableImage(file: File): Promise<File> {
+    if (serverImageTypes.includes(file.type) && !isHeic(file)) {
+        return file;
+    }
+    return toJpeg(file);
+}
+
+// toDisplayableImage is for images stored as uploaded, such as recipe
+// photos sent to UploadThing. Only HEIC is converted, because most browsers
+// cannot display it; WebP and the other web formats are kept.
+export async function toDisplayableImage(file: File): Promise<File> {
+    if (!isHeic(file)) {
+        return file;
+    }
+    return toJpeg(file);
+}
+
+// toJpeg draws the file as an upright JPEG, or returns it unchanged if the
+// browser cannot decode it.
+async function toJpeg(file: File): Promise<File> {
+    try {
+        const bitmap = await createImageBitmap(file, { imageOrientation: "from-image" });
+        const canvas = document.createElement("canvas");
+        canvas.width = bitmap.width;
+        canvas.height = bitmap.height;
+        const context = canvas.getContext("2d");
+        if (!context) {
+            return file;
+        }
+        context.fillStyle = "#fff";
+        context.fillRect(0, 0, canvas.width, canvas.height);
+        context.drawImage(bitmap, 0, 0);
+        bitmap.close();
+
+        const blob = await new Promise<Blob | null>((resolve) => canvas.toBlob(resolve, "image/jpeg", 0.9));
+        if (!blob) {
+            return file;
+        }
+        const name = file.name.replace(/\.[^.]*$/, "") + ".jpg";
+        return new File([blob], name, { type: "image/jpeg" });
+    } catch {
+        return file;
+    }
+}